@@ -0,0 +1,127 @@
+package delay
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Interval between the checks of the depth of the queues of WithAdaptiveConcurrency.
+const adaptiveInterval = 10 * time.Second
+
+type adaptiveConcurrency struct {
+	min         int
+	max         int
+	targetDepth int64
+
+	// Idle workers of the pool exit when receiving from quit.
+	quit chan struct{}
+}
+
+// WithAdaptiveConcurrency changes the number of workers of the pool between min and
+// max following the number of pending tasks of the queues handled by the listener.
+// Every 10 seconds a worker is added if there are more than targetDepth pending
+// tasks, or removed if there are less than half of them.
+//
+// It replaces WithWorkerCount and requires connections that support QueueDepth.
+func WithAdaptiveConcurrency(min, max int, targetDepth int64) ListenerOption {
+	return func(lis *Listener) {
+		if min < 1 {
+			min = 1
+		}
+		lis.workerCount = min
+		lis.adaptive = &adaptiveConcurrency{
+			min:         min,
+			max:         max,
+			targetDepth: targetDepth,
+			quit:        make(chan struct{}),
+		}
+	}
+}
+
+// runWorker runs the jobs of the pool until it is closed or the worker receives
+// from quit.
+func (lis *Listener) runWorker(quit <-chan struct{}) {
+	for {
+		select {
+		case job, ok := <-lis.jobs:
+			if !ok {
+				return
+			}
+			job()
+		case <-quit:
+			return
+		}
+	}
+}
+
+func (lis *Listener) adaptConcurrency() {
+	ticker := time.NewTicker(adaptiveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-lis.stopping:
+			return
+		case <-ticker.C:
+		}
+
+		depth, err := lis.queuesDepth(lis.ctx)
+		if err != nil {
+			lis.logger.WithField("error", err.Error()).Warning("Cannot read the depth of the queues to adapt the workers")
+			continue
+		}
+
+		lis.activeMu.Lock()
+		workers := lis.workerCount
+		lis.activeMu.Unlock()
+
+		// Change the workers one at a time to avoid oscillations.
+		switch {
+		case depth > lis.adaptive.targetDepth && workers < lis.adaptive.max:
+			go lis.runWorker(lis.adaptive.quit)
+			workers++
+
+		case depth < lis.adaptive.targetDepth/2 && workers > lis.adaptive.min:
+			// Only idle workers can exit, try again later if all of them are busy.
+			select {
+			case lis.adaptive.quit <- struct{}{}:
+				workers--
+			default:
+				continue
+			}
+
+		default:
+			continue
+		}
+
+		lis.activeMu.Lock()
+		lis.workerCount = workers
+		lis.activeMu.Unlock()
+		lis.resizeBudget()
+
+		lis.logger.WithFields(log.Fields{
+			"workers": workers,
+			"depth":   depth,
+		}).Debug("Workers adapted to the depth of the queues")
+	}
+}
+
+// queuesDepth returns the pending tasks of all the queues handled by the listener.
+func (lis *Listener) queuesDepth(ctx context.Context) (int64, error) {
+	lis.handledMu.Lock()
+	queues := append([]QueueSpec(nil), lis.handled...)
+	lis.handledMu.Unlock()
+
+	var total int64
+	for _, queue := range queues {
+		depth, err := queue.conn.QueueDepth(ctx, queue.name)
+		if err != nil {
+			return 0, err
+		}
+		total += depth
+	}
+
+	return total, nil
+}
@@ -0,0 +1,94 @@
+package delay
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	pb "github.com/altipla-consulting/delay/queues"
+)
+
+// Maximum time the wrappers returned by Function.Async wait to send each task.
+const asyncSendTimeout = 30 * time.Second
+
+// AsyncOption configures the wrappers returned by Function.Async.
+type AsyncOption func(opts *asyncOptions)
+
+type asyncOptions struct {
+	onError  func(err error)
+	reporter ErrorReporter
+}
+
+// WithOnError calls fn with the errors sending the tasks instead of logging them.
+func WithOnError(fn func(err error)) AsyncOption {
+	return func(opts *asyncOptions) {
+		opts.onError = fn
+	}
+}
+
+// WithAsyncErrorReporter sends the errors sending the tasks to the reporter, for
+// example the same one configured in the listeners with WithErrorReporter.
+func WithAsyncErrorReporter(reporter ErrorReporter) AsyncOption {
+	return func(opts *asyncOptions) {
+		opts.reporter = reporter
+	}
+}
+
+// Async returns a wrapper to call the function from code that cannot return errors,
+// like the HTTP handlers. The task is built right away, so the arguments can be
+// modified after calling the wrapper, and sent to the queue in the background.
+//
+// The task is sent even if ctx is cancelled when the request finishes, but the
+// values of ctx like the trace and the correlation ID are kept. Errors are logged
+// unless configured otherwise with the options. Duplicated tasks are ignored.
+func (f *Function) Async(queue QueueSpec, opts ...AsyncOption) func(ctx context.Context, args ...interface{}) {
+	var options asyncOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	handleErr := func(ctx context.Context, err error) {
+		switch {
+		case errors.Is(err, ErrDuplicate):
+		case options.onError != nil:
+			options.onError(err)
+		case options.reporter != nil:
+			options.reporter.Report(ctx, err)
+		default:
+			log.WithFields(log.Fields{
+				"error": err.Error(),
+				"queue": queue.name,
+				"key":   f.key,
+			}).Error("Cannot send task")
+		}
+	}
+
+	return func(ctx context.Context, args ...interface{}) {
+		task, err := f.Task(args...)
+		if err != nil {
+			handleErr(ctx, err)
+			return
+		}
+
+		ctx = detachedContext{ctx}
+		go func() {
+			ctx, cancel := context.WithTimeout(ctx, asyncSendTimeout)
+			defer cancel()
+
+			if err := queue.SendTasks(ctx, []*pb.SendTask{task}); err != nil {
+				handleErr(ctx, err)
+			}
+		}()
+	}
+}
+
+// detachedContext keeps the values of its parent without its cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (ctx detachedContext) Deadline() (time.Time, bool)       { return time.Time{}, false }
+func (ctx detachedContext) Done() <-chan struct{}             { return nil }
+func (ctx detachedContext) Err() error                        { return nil }
+func (ctx detachedContext) Value(key interface{}) interface{} { return ctx.parent.Value(key) }
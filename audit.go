@@ -0,0 +1,178 @@
+package delay
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	pb "github.com/altipla-consulting/delay/queues"
+)
+
+// Events written in the audit log.
+const (
+	AuditReceived     = "received"
+	AuditStarted      = "started"
+	AuditSucceeded    = "succeeded"
+	AuditFailed       = "failed"
+	AuditDeadLettered = "dead-lettered"
+)
+
+// AuditEntry is each line written in the audit log.
+type AuditEntry struct {
+	Timestamp    time.Time `json:"timestamp"`
+	Event        string    `json:"event"`
+	TaskCode     string    `json:"taskCode"`
+	Function     string    `json:"function"`
+	Queue        string    `json:"queue"`
+	Project      string    `json:"project"`
+	RetryCount   int32     `json:"retryCount"`
+	DurationMs   int64     `json:"durationMs"`
+	ErrorMessage string    `json:"errorMessage"`
+}
+
+type auditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// WithAuditLog writes a JSON line to w for each event of the tasks: received,
+// started, succeeded, failed and dead-lettered. Writers with a Flush or Sync method
+// are flushed after each line.
+//
+// Writers created with NewFileAuditLog are closed when the listener stops.
+func WithAuditLog(w io.Writer) ListenerOption {
+	return func(lis *Listener) {
+		lis.audit = &auditLog{w: w}
+	}
+}
+
+func (audit *auditLog) write(event string, task *pb.Task, f *Function, duration time.Duration, taskErr error) {
+	if audit == nil {
+		return
+	}
+
+	entry := AuditEntry{
+		Timestamp:  time.Now(),
+		Event:      event,
+		TaskCode:   task.Code,
+		Queue:      task.QueueName,
+		Project:    task.Project,
+		RetryCount: task.Retry,
+		DurationMs: duration.Milliseconds(),
+	}
+	if f != nil {
+		entry.Function = f.key
+	}
+	if taskErr != nil {
+		entry.ErrorMessage = taskErr.Error()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+
+	if _, err := audit.w.Write(line); err != nil {
+		return
+	}
+	switch w := audit.w.(type) {
+	case interface{ Flush() error }:
+		w.Flush()
+	case interface{ Sync() error }:
+		w.Sync()
+	}
+}
+
+func (audit *auditLog) close() error {
+	if audit == nil {
+		return nil
+	}
+
+	if file, ok := audit.w.(*FileAuditLog); ok {
+		return file.Close()
+	}
+
+	return nil
+}
+
+// FileAuditLog writes the audit log in a file of the disk. It can be used as
+// the writer of WithAuditLog.
+type FileAuditLog struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileAuditLog opens the file in the path to append the audit log. The file is
+// created if it does not exist.
+func NewFileAuditLog(path string) (*FileAuditLog, error) {
+	file, err := openAuditFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileAuditLog{
+		path: path,
+		file: file,
+	}, nil
+}
+
+func openAuditFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("delay: cannot open audit log: %w", err)
+	}
+
+	return file, nil
+}
+
+// Write implements io.Writer.
+func (audit *FileAuditLog) Write(p []byte) (int, error) {
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+
+	return audit.file.Write(p)
+}
+
+// Sync commits the written lines to the disk.
+func (audit *FileAuditLog) Sync() error {
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+
+	return audit.file.Sync()
+}
+
+// Reopen closes the file and opens again the path. It should be called after an
+// external tool like logrotate moves the file to continue writing in a new one.
+func (audit *FileAuditLog) Reopen() error {
+	file, err := openAuditFile(audit.path)
+	if err != nil {
+		return err
+	}
+
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+
+	old := audit.file
+	audit.file = file
+	if err := old.Close(); err != nil {
+		return fmt.Errorf("delay: cannot close audit log: %w", err)
+	}
+
+	return nil
+}
+
+// Close closes the file.
+func (audit *FileAuditLog) Close() error {
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+
+	return audit.file.Close()
+}
@@ -0,0 +1,88 @@
+package delay
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	pb "github.com/altipla-consulting/delay/queues"
+)
+
+// queueBackend is implemented by the queue services of the cloud providers that
+// can store the tasks instead of the queues server.
+type queueBackend interface {
+	sendTasks(ctx context.Context, queueName string, tasks []*pb.SendTask) error
+
+	// listen receives tasks until the context is cancelled and returns after all the
+	// calls to the handler have finished. The handler returns nil if the task should
+	// be acknowledged and the error of the task if it failed.
+	listen(ctx context.Context, queueName string, handler func(task *pb.Task) error) error
+
+	// ping checks the connection and the credentials of the backend.
+	ping(ctx context.Context) error
+
+	close() error
+}
+
+func (lis *Listener) listenBackend(queue QueueSpec, options handleOptions) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-lis.stopping:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	handler := func(task *pb.Task) error {
+		if task.Project == "" {
+			task.Project = queue.conn.project
+		}
+		if task.QueueName == "" {
+			task.QueueName = queue.name
+		}
+
+		lis.logger.WithFields(log.Fields{
+			"project": task.Project,
+			"queue":   task.QueueName,
+			"task":    task.Code,
+		}).Debug("Task received")
+
+		if !lis.waitResumed(ctx) {
+			return fmt.Errorf("delay: listener stopped while paused")
+		}
+		run := func() error {
+			requeued, err := lis.handleTask(lis.ctx, queue, task)
+			// Backends ack the task after the handler returns, the callbacks run right before.
+			defer lis.callbacks.taskCompleted(lis.ctx, task, err)
+			if requeued {
+				return nil
+			}
+			// Kafka sends the tasks that cannot be retried to its own dead-letter topic
+			// if nothing else took them, the rest of backends drop them.
+			if _, ok := queue.conn.backend.(*kafkaBackend); !ok && IsNonRetryable(err) {
+				return nil
+			}
+			return err
+		}
+		if lis.jobs == nil {
+			return run()
+		}
+
+		// Wait for a free worker of the pool to limit the tasks the backend receives.
+		result := make(chan error, 1)
+		job := func() {
+			result <- run()
+		}
+		select {
+		case lis.jobs <- job:
+		case options.reserved <- job:
+		}
+
+		return <-result
+	}
+
+	return queue.conn.backend.listen(ctx, queue.name, handler)
+}
@@ -0,0 +1,81 @@
+package delay
+
+import (
+	"context"
+
+	pb "github.com/altipla-consulting/delay/queues"
+)
+
+// BatchBuilder collects tasks of multiple functions to send them to a queue with
+// a single request.
+type BatchBuilder struct {
+	queue   QueueSpec
+	ctx     context.Context
+	maxSize int
+	tasks   []*pb.SendTask
+	err     error
+}
+
+// NewBatch creates a builder of tasks for the queue.
+func NewBatch(queue QueueSpec) *BatchBuilder {
+	return &BatchBuilder{
+		queue: queue,
+		ctx:   context.Background(),
+	}
+}
+
+// WithMaxSize sends the tasks automatically each time the batch reaches n tasks
+// to limit the size of the requests.
+func (batch *BatchBuilder) WithMaxSize(n int) *BatchBuilder {
+	batch.maxSize = n
+	return batch
+}
+
+// WithContext changes the context used to send the tasks automatically when the
+// batch reaches its maximum size.
+func (batch *BatchBuilder) WithContext(ctx context.Context) *BatchBuilder {
+	batch.ctx = ctx
+	return batch
+}
+
+// Add appends a task to the batch. It receives directly the result of Function.Task,
+// the error is returned later when sending the batch. Once an error happens the
+// rest of tasks are discarded.
+func (batch *BatchBuilder) Add(task *pb.SendTask, err error) *BatchBuilder {
+	if batch.err != nil {
+		return batch
+	}
+	if err != nil {
+		batch.err = err
+		return batch
+	}
+
+	batch.tasks = append(batch.tasks, task)
+	if batch.maxSize > 0 && len(batch.tasks) >= batch.maxSize {
+		batch.err = batch.flush(batch.ctx)
+	}
+
+	return batch
+}
+
+// Send sends the pending tasks of the batch to the queue. It returns the first error
+// found when building or sending the tasks without sending the pending ones.
+func (batch *BatchBuilder) Send(ctx context.Context) error {
+	if batch.err != nil {
+		return batch.err
+	}
+
+	return batch.flush(ctx)
+}
+
+func (batch *BatchBuilder) flush(ctx context.Context) error {
+	if len(batch.tasks) == 0 {
+		return nil
+	}
+	if err := batch.queue.SendTasks(ctx, batch.tasks); err != nil {
+		return err
+	}
+	batch.tasks = nil
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package delay
+
+import (
+	"context"
+
+	pb "github.com/altipla-consulting/delay/queues"
+)
+
+// BoundFunction is a function with some of its first arguments already fixed.
+type BoundFunction struct {
+	f    *Function
+	args []interface{}
+}
+
+// Bind returns a wrapper of the function that sends the arguments before the ones
+// passed to each task. It is useful when multiple call sites share the first
+// arguments of the function, like the tenant or the region. The full list of
+// arguments is checked when building the tasks.
+func (f *Function) Bind(args ...interface{}) *BoundFunction {
+	return &BoundFunction{
+		f:    f,
+		args: args,
+	}
+}
+
+// Function returns the underlying function.
+func (bf *BoundFunction) Function() *Function {
+	return bf.f
+}
+
+// Task builds a task to call the function with the bound arguments followed by
+// the remaining ones.
+func (bf *BoundFunction) Task(args ...interface{}) (*pb.SendTask, error) {
+	full := make([]interface{}, 0, len(bf.args)+len(args))
+	full = append(full, bf.args...)
+	full = append(full, args...)
+
+	return bf.f.Task(full...)
+}
+
+// Call sends a task to the queue to call the function with the bound arguments
+// followed by the remaining ones.
+func (bf *BoundFunction) Call(ctx context.Context, queue QueueSpec, args ...interface{}) error {
+	task, err := bf.Task(args...)
+	if err != nil {
+		return err
+	}
+
+	return queue.SendTasks(ctx, []*pb.SendTask{task})
+}
@@ -0,0 +1,189 @@
+package delay
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	pb "github.com/altipla-consulting/delay/queues"
+)
+
+// taskCallbacks are the functions registered in the listener to follow the
+// lifecycle of the tasks.
+type taskCallbacks struct {
+	mu        sync.RWMutex
+	hooks     []func(ctx context.Context, task *pb.Task) (context.Context, error)
+	received  []func(ctx context.Context, task *pb.Task)
+	started   []func(ctx context.Context, task *pb.Task)
+	succeeded []func(ctx context.Context, task *pb.Task, duration time.Duration)
+	failed    []func(ctx context.Context, task *pb.Task, err error, retrying bool)
+	errored   []func(ctx context.Context, task *pb.Task, err error)
+	completed []func(ctx context.Context, task *pb.Task, succeeded bool)
+	retried   []func(ctx context.Context, task *pb.Task, retryCount int32, nextETA time.Time)
+}
+
+// OnTask registers a hook called with each task before looking up its function. The
+// hook can return a new context for the function, for example with data of the
+// tenant of the task. If the hook fails the function does not run and the task is
+// retried like any other failure.
+func (lis *Listener) OnTask(fn func(ctx context.Context, task *pb.Task) (context.Context, error)) {
+	lis.callbacks.mu.Lock()
+	defer lis.callbacks.mu.Unlock()
+
+	lis.callbacks.hooks = append(lis.callbacks.hooks, fn)
+}
+
+// OnReceived registers a callback called each time a task is received from a queue,
+// before checking it.
+func (lis *Listener) OnReceived(fn func(ctx context.Context, task *pb.Task)) {
+	lis.callbacks.mu.Lock()
+	defer lis.callbacks.mu.Unlock()
+
+	lis.callbacks.received = append(lis.callbacks.received, fn)
+}
+
+// OnStarted registers a callback called each time the function of a task starts
+// running.
+func (lis *Listener) OnStarted(fn func(ctx context.Context, task *pb.Task)) {
+	lis.callbacks.mu.Lock()
+	defer lis.callbacks.mu.Unlock()
+
+	lis.callbacks.started = append(lis.callbacks.started, fn)
+}
+
+// OnSucceeded registers a callback called each time the function of a task finishes
+// without errors. It receives the time the function spent running.
+func (lis *Listener) OnSucceeded(fn func(ctx context.Context, task *pb.Task, duration time.Duration)) {
+	lis.callbacks.mu.Lock()
+	defer lis.callbacks.mu.Unlock()
+
+	lis.callbacks.succeeded = append(lis.callbacks.succeeded, fn)
+}
+
+// OnFailed registers a callback called each time a task fails. Retrying is true if
+// the task will run again later, either sent again by the retry policy of the
+// function or by the queues server.
+func (lis *Listener) OnFailed(fn func(ctx context.Context, task *pb.Task, err error, retrying bool)) {
+	lis.callbacks.mu.Lock()
+	defer lis.callbacks.mu.Unlock()
+
+	lis.callbacks.failed = append(lis.callbacks.failed, fn)
+}
+
+// OnError registers a callback called each time a task fails, after sending its ack
+// to the queues server. It is a lighter alternative to ErrorReporter for simple
+// side effects like counting the failures.
+func (lis *Listener) OnError(fn func(ctx context.Context, task *pb.Task, err error)) {
+	lis.callbacks.mu.Lock()
+	defer lis.callbacks.mu.Unlock()
+
+	lis.callbacks.errored = append(lis.callbacks.errored, fn)
+}
+
+// OnComplete registers a callback called each time a task finishes, successfully
+// or not, after sending its ack to the queues server.
+func (lis *Listener) OnComplete(fn func(ctx context.Context, task *pb.Task, succeeded bool)) {
+	lis.callbacks.mu.Lock()
+	defer lis.callbacks.mu.Unlock()
+
+	lis.callbacks.completed = append(lis.callbacks.completed, fn)
+}
+
+// OnRetry registers a callback called right before the listener sends a failed task
+// again to the queue, for example with the retry policy of its function. It receives
+// the retry count of the new task and the time it will run. The retries done by the
+// queues server itself are not reported.
+func (lis *Listener) OnRetry(fn func(ctx context.Context, task *pb.Task, retryCount int32, nextETA time.Time)) {
+	lis.callbacks.mu.Lock()
+	defer lis.callbacks.mu.Unlock()
+
+	lis.callbacks.retried = append(lis.callbacks.retried, fn)
+}
+
+func (cb *taskCallbacks) runHooks(ctx context.Context, task *pb.Task) (context.Context, error) {
+	cb.mu.RLock()
+	fns := cb.hooks
+	cb.mu.RUnlock()
+
+	for _, fn := range fns {
+		var err error
+		ctx, err = fn(ctx, task)
+		if err != nil {
+			return ctx, err
+		}
+	}
+
+	return ctx, nil
+}
+
+func (cb *taskCallbacks) taskReceived(ctx context.Context, task *pb.Task) {
+	cb.mu.RLock()
+	fns := cb.received
+	cb.mu.RUnlock()
+
+	for _, fn := range fns {
+		fn(ctx, task)
+	}
+}
+
+func (cb *taskCallbacks) taskStarted(ctx context.Context, task *pb.Task) {
+	cb.mu.RLock()
+	fns := cb.started
+	cb.mu.RUnlock()
+
+	for _, fn := range fns {
+		fn(ctx, task)
+	}
+}
+
+func (cb *taskCallbacks) taskSucceeded(ctx context.Context, task *pb.Task, duration time.Duration) {
+	cb.mu.RLock()
+	fns := cb.succeeded
+	cb.mu.RUnlock()
+
+	for _, fn := range fns {
+		fn(ctx, task, duration)
+	}
+}
+
+func (cb *taskCallbacks) taskFailed(ctx context.Context, task *pb.Task, err error, retrying bool) {
+	cb.mu.RLock()
+	fns := cb.failed
+	cb.mu.RUnlock()
+
+	for _, fn := range fns {
+		fn(ctx, task, err, retrying)
+	}
+}
+
+func (cb *taskCallbacks) taskRetried(ctx context.Context, task *pb.Task, retryCount int32, nextETA time.Time) {
+	cb.mu.RLock()
+	fns := cb.retried
+	cb.mu.RUnlock()
+
+	for _, fn := range fns {
+		fn(ctx, task, retryCount, nextETA)
+	}
+}
+
+// taskCompleted runs the callbacks of the tasks that finished with the error
+// returned by handleTask. Tasks discarded by the tag filter are ignored.
+func (cb *taskCallbacks) taskCompleted(ctx context.Context, task *pb.Task, err error) {
+	if err == errTaskFiltered {
+		return
+	}
+
+	cb.mu.RLock()
+	errored := cb.errored
+	completed := cb.completed
+	cb.mu.RUnlock()
+
+	if err != nil {
+		for _, fn := range errored {
+			fn(ctx, task, err)
+		}
+	}
+	for _, fn := range completed {
+		fn(ctx, task, err == nil)
+	}
+}
@@ -0,0 +1,191 @@
+package delay
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec serializes the invocation of a function to send it inside the payload
+// of a task.
+//
+// Custom codecs should be registered with RegisterCodec before using them.
+// Codec values must be comparable.
+type Codec interface {
+	Encode(v interface{}) ([]byte, error)
+	Decode(data []byte, v interface{}) error
+}
+
+var (
+	// GobCodec serializes the tasks with encoding/gob. It is the default codec
+	// but tasks can only be read by Go programs.
+	GobCodec Codec = gobCodec{}
+
+	// JSONCodec serializes the tasks with encoding/json so they can be read and
+	// produced by programs written in other languages.
+	JSONCodec Codec = jsonCodec{}
+
+	// MsgpackCodec serializes the tasks with MessagePack. It is portable to other
+	// languages like JSON but produces smaller payloads.
+	MsgpackCodec Codec = msgpackCodec{}
+)
+
+// Codec discriminators of the built-in codecs. Identifiers under 16 are reserved,
+// for example for the compressed payloads.
+//
+// Custom codecs use the identifiers between 128 and 247, that are never the first
+// byte of the legacy payloads without discriminator. Gob starts them with the
+// length of the first message, under 128 or a byte count from 248 to 255.
+const (
+	codecGob     byte = 1
+	codecJSON    byte = 2
+	codecMsgpack byte = 6
+
+	minCustomCodec byte = 128
+	maxCustomCodec byte = 247
+)
+
+var codecs = map[byte]Codec{
+	codecGob:     GobCodec,
+	codecJSON:    JSONCodec,
+	codecMsgpack: MsgpackCodec,
+}
+
+// RegisterCodec makes a custom codec available to the functions. The identifier
+// is written as the first byte of the payload to detect the codec when receiving
+// the task and it should be the same in all the programs that share the queues.
+//
+// The identifier should be between 128 and 247. RegisterCodec only expects to be
+// called during initialization.
+func RegisterCodec(id byte, codec Codec) error {
+	if id < minCustomCodec || id > maxCustomCodec {
+		return fmt.Errorf("delay: codec identifier %d out of the range %d-%d", id, minCustomCodec, maxCustomCodec)
+	}
+	if codecs[id] != nil {
+		return fmt.Errorf("delay: codec identifier %d already registered", id)
+	}
+	codecs[id] = codec
+
+	return nil
+}
+
+func codecID(codec Codec) (byte, bool) {
+	for id, c := range codecs {
+		if c == codec {
+			return id, true
+		}
+	}
+
+	return 0, false
+}
+
+func encodePayload(codec Codec, inv invocation) ([]byte, error) {
+	id, ok := codecID(codec)
+	if !ok {
+		return nil, fmt.Errorf("delay: codec not registered: %T", codec)
+	}
+
+	data, err := codec.Encode(inv)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte{id}, data...), nil
+}
+
+func decodePayload(payload []byte) (Codec, invocation, error) {
+	var inv invocation
+
+	codec, data, err := splitPayload(payload)
+	if err != nil {
+		return nil, inv, err
+	}
+	if err := codec.Decode(data, &inv); err != nil {
+		return nil, inv, err
+	}
+
+	return codec, inv, nil
+}
+
+// splitPayload decompresses the payload and returns its codec and the encoded
+// invocation without the discriminator.
+func splitPayload(payload []byte) (Codec, []byte, error) {
+	payload, err := decompressPayload(payload)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var codec Codec
+	if len(payload) > 0 {
+		codec = codecs[payload[0]]
+		if codec == nil && payload[0] >= minCustomCodec && payload[0] <= maxCustomCodec {
+			return nil, nil, fmt.Errorf("delay: codec identifier %d not registered", payload[0])
+		}
+	}
+	if codec == nil {
+		// Tasks encoded before the codec discriminator was introduced are a
+		// raw gob stream. Gob never starts a stream with such small message lengths.
+		return GobCodec, payload, nil
+	}
+
+	return codec, payload[1:], nil
+}
+
+// convertArg adapts a decoded argument to the type the function expects. Codecs
+// that do not preserve the Go types, like JSON, decode generic values that should
+// be encoded and decoded again with the exact type.
+func convertArg(codec Codec, arg interface{}, at reflect.Type) (reflect.Value, error) {
+	if reflect.TypeOf(arg).AssignableTo(at) {
+		return reflect.ValueOf(arg), nil
+	}
+
+	data, err := codec.Encode(arg)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	v := reflect.New(at)
+	if err := codec.Decode(data, v.Interface()); err != nil {
+		return reflect.Value{}, err
+	}
+
+	return v.Elem(), nil
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Encode(v interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Decode(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Decode(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Encode(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Decode(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
@@ -0,0 +1,134 @@
+package delay
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressionAlgorithm compresses the payload of the tasks.
+type CompressionAlgorithm int
+
+const (
+	// CompressionNone sends the payloads without compression.
+	CompressionNone CompressionAlgorithm = iota
+
+	// CompressionGzip compresses the payloads with gzip.
+	CompressionGzip
+
+	// CompressionZstd compresses the payloads with Zstandard. It is faster than gzip
+	// and usually produces smaller payloads.
+	CompressionZstd
+)
+
+// Default size of the smallest payload that gets compressed.
+const DefaultCompressionThreshold = 512
+
+// Discriminators written before the compressed payloads. They use reserved codec
+// identifiers to detect them before decoding the payload.
+const (
+	payloadGzip byte = 3
+	payloadZstd byte = 4
+)
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+// WithCompression compresses the payload of the tasks of the function with the
+// algorithm. Payloads smaller than the threshold, DefaultCompressionThreshold if
+// not changed with WithCompressionThreshold, are sent without compression.
+//
+// All the programs that receive the tasks should understand the algorithm.
+func WithCompression(algorithm CompressionAlgorithm) FuncOption {
+	return func(f *Function) {
+		f.compression = algorithm
+	}
+}
+
+// WithCompressionThreshold changes the size in bytes of the smallest payload that
+// gets compressed with the algorithm of WithCompression.
+func WithCompressionThreshold(size int) FuncOption {
+	return func(f *Function) {
+		f.compressionThreshold = size
+	}
+}
+
+func initZstd() error {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil)
+		if zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil)
+	})
+
+	return zstdErr
+}
+
+func compressPayload(algorithm CompressionAlgorithm, threshold int, payload []byte) ([]byte, error) {
+	if algorithm == CompressionNone || len(payload) < threshold {
+		return payload, nil
+	}
+
+	switch algorithm {
+	case CompressionGzip:
+		buf := bytes.NewBuffer([]byte{payloadGzip})
+		w := gzip.NewWriter(buf)
+		if _, err := w.Write(payload); err != nil {
+			return nil, fmt.Errorf("delay: cannot compress payload: %w", err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("delay: cannot compress payload: %w", err)
+		}
+		return buf.Bytes(), nil
+
+	case CompressionZstd:
+		if err := initZstd(); err != nil {
+			return nil, fmt.Errorf("delay: cannot compress payload: %w", err)
+		}
+		return zstdEncoder.EncodeAll(payload, []byte{payloadZstd}), nil
+	}
+
+	return nil, fmt.Errorf("delay: unknown compression algorithm: %d", algorithm)
+}
+
+// decompressPayload returns the original payload if it was compressed.
+func decompressPayload(payload []byte) ([]byte, error) {
+	if len(payload) == 0 {
+		return payload, nil
+	}
+
+	switch payload[0] {
+	case payloadGzip:
+		r, err := gzip.NewReader(bytes.NewReader(payload[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("delay: cannot decompress payload: %w", err)
+		}
+		defer r.Close()
+		decompressed, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("delay: cannot decompress payload: %w", err)
+		}
+		return decompressed, nil
+
+	case payloadZstd:
+		if err := initZstd(); err != nil {
+			return nil, fmt.Errorf("delay: cannot decompress payload: %w", err)
+		}
+		decompressed, err := zstdDecoder.DecodeAll(payload[1:], nil)
+		if err != nil {
+			return nil, fmt.Errorf("delay: cannot decompress payload: %w", err)
+		}
+		return decompressed, nil
+	}
+
+	return payload, nil
+}
@@ -3,55 +3,252 @@ package delay
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/altipla-consulting/datetime"
 	"github.com/go-redis/redis"
 	"github.com/golang/protobuf/proto"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
 
 	pb "github.com/altipla-consulting/delay/queues"
 )
 
-const beauthTokenEndpoint = "https://beauth.io/token"
+const (
+	beauthTokenEndpoint = "https://beauth.io/token"
+	defaultServerAddr   = "api-v3.altipla.consulting:443"
+)
+
+// ErrConnClosed is returned when using a connection after closing it.
+var ErrConnClosed = errors.New("delay: connection closed")
 
 // Conn represents a connection to the queues server.
 type Conn struct {
 	project      string
+	cc           *grpc.ClientConn
 	queuesClient pb.QueuesServiceClient
 	redisClient  *redis.Client
+	redisStream  *redisStream
+	redisPrefix  string
+	memory       *InMemoryConn
+	backend      queueBackend
+	closed       int32
+
+	// parent is the connection that owns the resources of the views created with
+	// WithProject.
+	parent *Conn
 }
 
 // NewConn opens a new connection to a queues server. It needs the project and the OAuth
 // client credentials to authenticate the requests.
-func NewConn(project, clientID, clientSecret string) (*Conn, error) {
+//
+// Additional gRPC dial options are applied after the default ones, so they can add
+// interceptors or replace the transport credentials and the dialer when testing.
+func NewConn(project, clientID, clientSecret string, opts ...grpc.DialOption) (*Conn, error) {
+	return newConn(defaultServerAddr, project, clientID, clientSecret, opts)
+}
+
+// newConn opens the connection to the queues server listening in the address.
+func newConn(addr, project, clientID, clientSecret string, opts []grpc.DialOption) (*Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("delay: invalid server address %q: %w", addr, err)
+	}
+
 	config := &clientcredentials.Config{
 		ClientID:     clientID,
 		ClientSecret: clientSecret,
 		TokenURL:     beauthTokenEndpoint,
 	}
 	rpcCreds := grpc.WithPerRPCCredentials(oauthAccess{config.TokenSource(context.Background())})
-	creds := credentials.NewTLS(&tls.Config{ServerName: "api-v3.altipla.consulting"})
-	conn, err := grpc.Dial("api-v3.altipla.consulting:443", grpc.WithTransportCredentials(creds), rpcCreds)
+	creds := credentials.NewTLS(&tls.Config{ServerName: host})
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(creds), rpcCreds}, opts...)
+	conn, err := grpc.Dial(addr, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("delay: cannot connect to altipla api: %v", err)
+		return nil, fmt.Errorf("delay: cannot connect to altipla api: %w", err)
 	}
 
 	return &Conn{
 		project:      project,
+		cc:           conn,
 		queuesClient: pb.NewQueuesServiceClient(conn),
 	}, nil
 }
 
+// WithKeepalive sends pings through the connection to detect the broken ones and
+// reconnect sooner. Listeners can otherwise keep waiting for tasks forever in a
+// connection that was silently dropped by a load balancer or a network partition.
+// Pass it to NewConn as any other dial option.
+//
+// Reasonable values in production are a Time of 10 seconds and a Timeout of 5
+// seconds. Enable PermitWithoutStream to check the connections that only send tasks.
+func WithKeepalive(params keepalive.ClientParameters) grpc.DialOption {
+	return grpc.WithKeepaliveParams(params)
+}
+
+// WithMaxRecvMsgSize changes the maximum size in bytes of the messages received from
+// the queues server. By default gRPC limits them to 4 MB, which includes all the
+// tasks of each message. Pass it to NewConn as any other dial option.
+func WithMaxRecvMsgSize(bytes int) grpc.DialOption {
+	return grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(bytes))
+}
+
+// WithMaxSendMsgSize changes the maximum size in bytes of the messages sent to the
+// queues server, including all the tasks of a batch. The server has its own limit
+// and rejects larger messages independently of this value, so raising it only helps
+// up to the size accepted by the server. Pass it to NewConn as any other dial option.
+func WithMaxSendMsgSize(bytes int) grpc.DialOption {
+	return grpc.WithDefaultCallOptions(grpc.MaxCallSendMsgSize(bytes))
+}
+
+// NewConnFromEnv opens a new connection to a queues server reading the project and
+// the OAuth client credentials from the environment variables DELAY_PROJECT,
+// DELAY_CLIENT_ID and DELAY_CLIENT_SECRET.
+func NewConnFromEnv() (*Conn, error) {
+	return NewConnFromEnvPrefix("")
+}
+
+// NewConnFromEnvPrefix is like NewConnFromEnv but the names of the environment
+// variables start with the prefix. For example the prefix "BILLING" reads
+// BILLING_DELAY_PROJECT, BILLING_DELAY_CLIENT_ID and BILLING_DELAY_CLIENT_SECRET.
+func NewConnFromEnvPrefix(prefix string) (*Conn, error) {
+	if prefix != "" && !strings.HasSuffix(prefix, "_") {
+		prefix += "_"
+	}
+
+	var values []string
+	for _, name := range []string{"DELAY_PROJECT", "DELAY_CLIENT_ID", "DELAY_CLIENT_SECRET"} {
+		value := os.Getenv(prefix + name)
+		if value == "" {
+			return nil, fmt.Errorf("delay: environment variable %s required", prefix+name)
+		}
+		values = append(values, value)
+	}
+
+	return NewConn(values[0], values[1], values[2])
+}
+
+// Close releases the resources of the connection. Queues of a closed connection
+// return ErrConnClosed when sending or listening to them. The Redis client passed
+// to NewConnRedisStream is not closed.
+//
+// Closing a connection also closes the views created from it with WithProject.
+// Closing a view only invalidates the view itself.
+func (conn *Conn) Close() error {
+	if !atomic.CompareAndSwapInt32(&conn.closed, 0, 1) {
+		return ErrConnClosed
+	}
+	if conn.parent != nil {
+		return nil
+	}
+
+	switch {
+	case conn.cc != nil:
+		return conn.cc.Close()
+	case conn.redisClient != nil:
+		return conn.redisClient.Close()
+	case conn.redisStream != nil && conn.redisStream.ownClient:
+		return conn.redisStream.client.Close()
+	case conn.backend != nil:
+		return conn.backend.close()
+	}
+
+	return nil
+}
+
+func (conn *Conn) isClosed() bool {
+	if conn.parent != nil && conn.parent.isClosed() {
+		return true
+	}
+
+	return atomic.LoadInt32(&conn.closed) == 1
+}
+
+// WithProject returns a view of the connection that sends and receives the tasks
+// of other project. It shares the underlying connection and credentials, so it is
+// cheap to create one for each project of a multi-tenant application.
+func (conn *Conn) WithProject(project string) *Conn {
+	parent := conn
+	if conn.parent != nil {
+		parent = conn.parent
+	}
+
+	return &Conn{
+		project:      project,
+		cc:           conn.cc,
+		queuesClient: conn.queuesClient,
+		redisClient:  conn.redisClient,
+		redisStream:  conn.redisStream,
+		redisPrefix:  conn.redisPrefix,
+		memory:       conn.memory,
+		backend:      conn.backend,
+		parent:       parent,
+	}
+}
+
+// Ping checks the connection and the credentials sending a request to the queues
+// server, or to the storage of the other kinds of connections. Call it before
+// accepting traffic to fail fast if the queues are not available.
+func (conn *Conn) Ping(ctx context.Context) error {
+	if conn.isClosed() {
+		return ErrConnClosed
+	}
+
+	switch {
+	case conn.memory != nil:
+		return nil
+
+	case conn.redisStream != nil:
+		if err := redisWithContext(ctx, conn.redisStream.client).Ping().Err(); err != nil {
+			return fmt.Errorf("delay: cannot ping redis: %w", err)
+		}
+		return nil
+
+	case conn.redisClient != nil:
+		if err := conn.redisClient.WithContext(ctx).Ping().Err(); err != nil {
+			return fmt.Errorf("delay: cannot ping redis: %w", err)
+		}
+		return nil
+
+	case conn.backend != nil:
+		return conn.backend.ping(ctx)
+	}
+
+	// The OAuth token is obtained before sending the request, so a server without
+	// the health service still proves the credentials and the connection are valid.
+	_, err := healthpb.NewHealthClient(conn.cc).Check(ctx, new(healthpb.HealthCheckRequest))
+	if err != nil && status.Code(err) != codes.Unimplemented {
+		return fmt.Errorf("delay: cannot ping altipla api: %w", err)
+	}
+
+	return nil
+}
+
 // NewDebugConn creates a new local debugging connection that uses a direct Redis
 // queue to simulate the queue. The downside is both the sender and receiver should
 // be connected at the same time to send the message; there is no storage.
-func NewDebugConn() (*Conn, error) {
-	return &Conn{
+func NewDebugConn(opts ...RedisOption) (*Conn, error) {
+	conn := &Conn{
 		redisClient: redis.NewClient(&redis.Options{Addr: "redis:6379"}),
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(conn)
+	}
+
+	return conn, nil
 }
 
 type oauthAccess struct {
@@ -61,7 +258,7 @@ type oauthAccess struct {
 func (oa oauthAccess) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
 	token, err := oa.tokenSource.Token()
 	if err != nil {
-		return nil, fmt.Errorf("delay: cannot update token: %v", err)
+		return nil, fmt.Errorf("delay: cannot update token: %w", err)
 	}
 
 	return map[string]string{
@@ -75,29 +272,79 @@ func (oa oauthAccess) RequireTransportSecurity() bool {
 
 // QueueSpec contains a reference to a queue to send to and receive tasks from that queue.
 type QueueSpec struct {
-	conn *Conn
-	name string
+	conn    *Conn
+	name    string
+	options *queueOptions
 }
 
 // Queue builds a new QueueSpec reference to a queue.
 func Queue(conn *Conn, name string) QueueSpec {
-	return QueueSpec{conn, name}
+	return QueueSpec{conn: conn, name: name}
+}
+
+// Name returns the name of the queue.
+func (queue QueueSpec) Name() string {
+	return queue.name
 }
 
-// SendTasks sends a list of tasks in batch to a queue.
+// WithName returns a reference to other queue of the same connection. It is useful
+// to build queues per tenant from a base queue.
+func (queue QueueSpec) WithName(name string) QueueSpec {
+	queue.name = name
+	return queue
+}
+
+// WithConn returns a reference to the same queue in other connection, keeping the
+// rest of options. It is useful to point the queues to a test connection or to build
+// them per region from a base queue.
+func (queue QueueSpec) WithConn(conn *Conn) QueueSpec {
+	queue.conn = conn
+	return queue
+}
+
+// SendTasks sends a list of tasks in batch to a queue. The trace context and the
+// correlation ID of ctx are saved in the headers of the tasks to continue the trace
+// when running them.
 func (queue QueueSpec) SendTasks(ctx context.Context, tasks []*pb.SendTask) error {
+	_, err := queue.sendTasks(ctx, tasks)
+	return err
+}
+
+// sendTasks sends the tasks and returns the codes assigned by the queues server.
+// The rest of backends do not assign codes when sending the tasks.
+func (queue QueueSpec) sendTasks(ctx context.Context, tasks []*pb.SendTask) ([]string, error) {
+	if queue.conn.isClosed() {
+		return nil, ErrConnClosed
+	}
+
+	for _, task := range tasks {
+		injectTraceContext(ctx, task)
+		injectCorrelationID(ctx, task)
+	}
+
+	if queue.conn.memory != nil {
+		queue.conn.memory.sendTasks(queue.name, tasks)
+		return nil, nil
+	}
+	if queue.conn.redisStream != nil {
+		return nil, queue.conn.redisStream.sendTasks(queue.redisKey(), tasks)
+	}
+	if queue.conn.backend != nil {
+		return nil, queue.conn.backend.sendTasks(ctx, queue.name, tasks)
+	}
+
 	if queue.conn.redisClient != nil {
 		var buf proto.Buffer
 		for _, task := range tasks {
 			if err := buf.EncodeMessage(task); err != nil {
-				return fmt.Errorf("delay: cannot encode task: %v", err)
+				return nil, fmt.Errorf("delay: cannot encode task: %w", err)
 			}
 		}
-		if err := queue.conn.redisClient.Publish(queue.name, buf.Bytes()).Err(); err != nil {
-			return fmt.Errorf("delay: cannot send to the debug queue: %v", err)
+		if err := queue.conn.redisClient.Publish(queue.redisKey(), buf.Bytes()).Err(); err != nil {
+			return nil, fmt.Errorf("delay: cannot send to the debug queue: %w", err)
 		}
 
-		return nil
+		return nil, nil
 	}
 
 	req := &pb.SendTasksRequest{
@@ -105,11 +352,140 @@ func (queue QueueSpec) SendTasks(ctx context.Context, tasks []*pb.SendTask) erro
 		QueueName: queue.name,
 		Tasks:     tasks,
 	}
-	var err error
-	_, err = queue.conn.queuesClient.SendTasks(ctx, req)
+	reply, err := queue.conn.queuesClient.SendTasks(ctx, req)
 	if err != nil {
-		return fmt.Errorf("delay: cannot send tasks: %v", err)
+		return nil, fmt.Errorf("delay: cannot send tasks: %w", err)
 	}
 
-	return nil
+	return reply.Codes, nil
+}
+
+// PurgeOption configures the purge of a queue.
+type PurgeOption func(req *pb.PurgeRequest)
+
+// WithConfirm confirms the purge of the queue. The queues server refuses to purge
+// the queues of production projects without it.
+func WithConfirm(confirm bool) PurgeOption {
+	return func(req *pb.PurgeRequest) {
+		req.Confirm = confirm
+	}
+}
+
+// Purge discards all the pending tasks of the queue and returns how many of them
+// were removed. Tasks that are running are not affected. Only the queues server
+// and the in-memory connections support purging queues.
+func (queue QueueSpec) Purge(ctx context.Context, opts ...PurgeOption) (int64, error) {
+	if queue.conn.isClosed() {
+		return 0, ErrConnClosed
+	}
+
+	if queue.conn.memory != nil {
+		return queue.conn.memory.purge(queue.name), nil
+	}
+	if queue.conn.queuesClient == nil {
+		return 0, fmt.Errorf("delay: purge not supported by the connection")
+	}
+
+	req := &pb.PurgeRequest{
+		Project:   queue.conn.project,
+		QueueName: queue.name,
+	}
+	for _, opt := range opts {
+		opt(req)
+	}
+	reply, err := queue.conn.queuesClient.Purge(ctx, req)
+	if err != nil {
+		return 0, fmt.Errorf("delay: cannot purge queue: %w", err)
+	}
+
+	return reply.Purged, nil
+}
+
+// QueueStatus is the runtime state of a queue.
+type QueueStatus string
+
+const (
+	// QueueActive queues accept and run tasks.
+	QueueActive QueueStatus = "active"
+
+	// QueuePaused queues accept tasks but do not run them until resumed.
+	QueuePaused QueueStatus = "paused"
+
+	// QueueDraining queues do not accept new tasks but run the pending ones.
+	QueueDraining QueueStatus = "draining"
+)
+
+// QueueInfo describes a queue of the project.
+type QueueInfo struct {
+	// Name of the queue.
+	Name string
+
+	// Depth is the number of pending tasks in the queue.
+	Depth int64
+
+	// Created is the time when the queue was created.
+	Created time.Time
+
+	// Status is the runtime state of the queue.
+	Status QueueStatus
+}
+
+// ListQueues returns the queues of the project sorted by name. Only the queues
+// server and the in-memory connections support listing queues.
+func (conn *Conn) ListQueues(ctx context.Context) ([]QueueInfo, error) {
+	if conn.isClosed() {
+		return nil, ErrConnClosed
+	}
+
+	if conn.memory != nil {
+		return conn.memory.listQueues(), nil
+	}
+	if conn.queuesClient == nil {
+		return nil, fmt.Errorf("delay: listing queues not supported by the connection")
+	}
+
+	reply, err := conn.queuesClient.List(ctx, &pb.ListRequest{Project: conn.project})
+	if err != nil {
+		return nil, fmt.Errorf("delay: cannot list queues: %w", err)
+	}
+
+	infos := make([]QueueInfo, 0, len(reply.Queues))
+	for _, queue := range reply.Queues {
+		info := QueueInfo{
+			Name:   queue.Name,
+			Depth:  queue.Depth,
+			Status: QueueActive,
+		}
+		if queue.Created != nil {
+			info.Created = datetime.ParseTimestamp(queue.Created)
+		}
+		switch {
+		case queue.Status == pb.Queue_STATUS_DRAINING:
+			info.Status = QueueDraining
+		case queue.Status == pb.Queue_STATUS_PAUSED, queue.Paused:
+			info.Status = QueuePaused
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+
+	return infos, nil
+}
+
+// QueueDepth returns the number of pending tasks in the queue. It has the same
+// support as ListQueues.
+func (conn *Conn) QueueDepth(ctx context.Context, queueName string) (int64, error) {
+	infos, err := conn.ListQueues(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, info := range infos {
+		if info.Name == queueName {
+			return info.Depth, nil
+		}
+	}
+
+	return 0, fmt.Errorf("delay: queue %q not found", queueName)
 }
@@ -0,0 +1,123 @@
+package delay
+
+import (
+	"context"
+
+	pb "github.com/altipla-consulting/delay/queues"
+)
+
+// HeaderCorrelationID is the header of the tasks that stores the correlation ID
+// of the context that sent them.
+const HeaderCorrelationID = "delay-correlation-id"
+
+type contextKey int
+
+const (
+	keyTaskCode contextKey = iota
+	keyQueueName
+	keyRetryCount
+	keyHeaders
+	keyCorrelationID
+	keyProgressReporter
+	keyLogArgs
+)
+
+func withTaskCode(ctx context.Context, code string) context.Context {
+	return context.WithValue(ctx, keyTaskCode, code)
+}
+
+func withQueueName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, keyQueueName, name)
+}
+
+func withRetryCount(ctx context.Context, retry int32) context.Context {
+	return context.WithValue(ctx, keyRetryCount, retry)
+}
+
+func withHeaders(ctx context.Context, headers map[string]string) context.Context {
+	return context.WithValue(ctx, keyHeaders, headers)
+}
+
+// TaskCodeFromContext returns the code of the task that is running. It returns
+// an empty string outside of a task handler.
+func TaskCodeFromContext(ctx context.Context) string {
+	code, _ := ctx.Value(keyTaskCode).(string)
+	return code
+}
+
+// QueueNameFromContext returns the name of the queue of the task that is running.
+// It returns an empty string outside of a task handler.
+func QueueNameFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(keyQueueName).(string)
+	return name
+}
+
+// RetryCountFromContext returns the number of times the running task was retried
+// before. It returns zero in the first execution or outside of a task handler.
+func RetryCountFromContext(ctx context.Context) int32 {
+	retry, _ := ctx.Value(keyRetryCount).(int32)
+	return retry
+}
+
+// HeaderFromContext returns the value of a header of the task that is running. It
+// returns an empty string if the header is not present or outside of a task handler.
+func HeaderFromContext(ctx context.Context, key string) string {
+	headers, _ := ctx.Value(keyHeaders).(map[string]string)
+	return headers[key]
+}
+
+// HeadersFromContext returns a copy of all the headers of the task that is running.
+// It returns nil outside of a task handler.
+func HeadersFromContext(ctx context.Context) map[string]string {
+	headers, _ := ctx.Value(keyHeaders).(map[string]string)
+	if headers == nil {
+		return nil
+	}
+
+	cp := make(map[string]string, len(headers))
+	for k, v := range headers {
+		cp[k] = v
+	}
+	return cp
+}
+
+func withLogArgs(ctx context.Context, args []interface{}) context.Context {
+	return context.WithValue(ctx, keyLogArgs, args)
+}
+
+// LogArgsFromContext returns the arguments of the task that is running sanitized
+// by the function registered with WithArgSanitizer, safe to include in the logs.
+// It returns nil if the function has no sanitizer or outside of a task handler.
+func LogArgsFromContext(ctx context.Context) []interface{} {
+	args, _ := ctx.Value(keyLogArgs).([]interface{})
+	return args
+}
+
+// WithCorrelationID stores the correlation ID in the context. Tasks sent with the
+// context save it in their headers and the handlers receive it back in their own
+// context to correlate the logs across the asynchronous boundaries.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, keyCorrelationID, id)
+}
+
+// CorrelationIDFromContext returns the correlation ID of the context. Inside a task
+// handler it returns the one of the context that sent the task. It returns an empty
+// string if there is none.
+func CorrelationIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(keyCorrelationID).(string)
+	return id
+}
+
+// injectCorrelationID saves the correlation ID of the context in the headers of the
+// task. Tasks that already have one, like the retries, keep the original one.
+func injectCorrelationID(ctx context.Context, task *pb.SendTask) {
+	id := CorrelationIDFromContext(ctx)
+	if id == "" || task.Headers[HeaderCorrelationID] != "" {
+		return
+	}
+
+	if task.Headers == nil {
+		task.Headers = make(map[string]string)
+	}
+	task.Headers[HeaderCorrelationID] = id
+}
@@ -0,0 +1,142 @@
+package delay
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+)
+
+// WithCost assigns a weight to the tasks of the function in the pool of workers of
+// the listeners configured with WithWorkerCount. Each worker of the pool is a slot
+// and a task with cost n waits until n slots are free before running, so a few
+// expensive tasks cannot use all the capacity shared with the cheap ones.
+//
+// The default cost is 1. Tasks with a cost higher than the size of the pool run
+// alone using all of it. It has no effect in listeners without a pool.
+func WithCost(n float64) FuncOption {
+	return func(f *Function) {
+		f.cost = n
+	}
+}
+
+// taskCost returns the slots of the pool used by the tasks of the function.
+func (f *Function) taskCost() float64 {
+	if f.cost <= 0 {
+		return 1
+	}
+
+	return f.cost
+}
+
+// costBudget is a weighted semaphore of the slots of the pool. Tasks acquire their
+// slots in order of arrival, so the expensive tasks are not starved by the cheap
+// ones that would fit before them.
+type costBudget struct {
+	mu       sync.Mutex
+	capacity float64
+	used     float64
+	waiters  list.List
+}
+
+type costWaiter struct {
+	n     float64
+	ready chan struct{}
+}
+
+func newCostBudget(capacity float64) *costBudget {
+	return &costBudget{capacity: capacity}
+}
+
+// acquire blocks until n slots are free and returns the slots acquired, that
+// should be passed to release once the task finishes.
+func (budget *costBudget) acquire(ctx context.Context, n float64) (float64, error) {
+	budget.mu.Lock()
+	if n > budget.capacity {
+		n = budget.capacity
+	}
+	if budget.waiters.Len() == 0 && budget.used+n <= budget.capacity {
+		budget.used += n
+		budget.mu.Unlock()
+		return n, nil
+	}
+
+	w := &costWaiter{n: n, ready: make(chan struct{})}
+	elem := budget.waiters.PushBack(w)
+	budget.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return w.n, nil
+
+	case <-ctx.Done():
+		budget.mu.Lock()
+		defer budget.mu.Unlock()
+
+		select {
+		case <-w.ready:
+			// Slots were acquired right after the cancellation, return them.
+			budget.used -= w.n
+		default:
+			budget.waiters.Remove(elem)
+		}
+		budget.notify()
+
+		return 0, fmt.Errorf("delay: cannot acquire the cost of the task: %w", ctx.Err())
+	}
+}
+
+func (budget *costBudget) release(n float64) {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+
+	budget.used -= n
+	if budget.used < 1e-9 {
+		// Avoid the rounding errors of the fractional costs blocking the budget.
+		budget.used = 0
+	}
+	budget.notify()
+}
+
+// resize changes the slots of the budget when the workers of the pool change.
+func (budget *costBudget) resize(capacity float64) {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+
+	budget.capacity = capacity
+	budget.notify()
+}
+
+// notify wakes up the waiters in order while their slots fit. It should be called
+// with the lock held.
+func (budget *costBudget) notify() {
+	for {
+		elem := budget.waiters.Front()
+		if elem == nil {
+			return
+		}
+		w := elem.Value.(*costWaiter)
+		if w.n > budget.capacity {
+			w.n = budget.capacity
+		}
+		if budget.used+w.n > budget.capacity {
+			return
+		}
+		budget.used += w.n
+		budget.waiters.Remove(elem)
+		close(w.ready)
+	}
+}
+
+// resizeBudget updates the budget of the pool with the current number of workers.
+func (lis *Listener) resizeBudget() {
+	if lis.budget == nil {
+		return
+	}
+
+	lis.activeMu.Lock()
+	workers := lis.workerCount + lis.reservedWorkers
+	lis.activeMu.Unlock()
+
+	lis.budget.resize(float64(workers))
+}
@@ -0,0 +1,153 @@
+package delay
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	log "github.com/sirupsen/logrus"
+)
+
+// Locker is a distributed lock shared by all the instances of the application
+// that run the same cron jobs.
+type Locker interface {
+	// Acquire tries to take the lock of the key during ttl. It returns false if
+	// other instance already has it.
+	Acquire(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+// CronJob sends a task to a queue periodically following a cron schedule.
+type CronJob struct {
+	schedule cron.Schedule
+	f        *Function
+	queue    QueueSpec
+	args     []interface{}
+	locker   Locker
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+// CronOption configures a cron job when creating it. Options can be passed to Cron()
+// mixed with the arguments of the function.
+type CronOption func(job *CronJob)
+
+// WithLeaderLock uses the distributed lock to send the task only from one of the
+// instances that run the cron job at each scheduled time.
+func WithLeaderLock(store Locker) CronOption {
+	return func(job *CronJob) {
+		job.locker = store
+	}
+}
+
+// Cron prepares a job that sends a task to the function with the arguments each
+// time the standard 5-field cron expression is due. The job does not start until
+// calling Start.
+//
+// Any CronOption found in the arguments will configure the job instead of sending
+// it to the function.
+func Cron(schedule string, f *Function, queue QueueSpec, args ...interface{}) (*CronJob, error) {
+	sched, err := cron.ParseStandard(schedule)
+	if err != nil {
+		return nil, fmt.Errorf("delay: cannot parse cron schedule %q: %w", schedule, err)
+	}
+
+	job := &CronJob{
+		schedule: sched,
+		f:        f,
+		queue:    queue,
+	}
+	for _, arg := range args {
+		if opt, ok := arg.(CronOption); ok {
+			opt(job)
+			continue
+		}
+		job.args = append(job.args, arg)
+	}
+
+	// Check the arguments before starting to avoid failing at every tick.
+	if _, _, err := f.buildTask(job.args...); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// Start runs the scheduling loop of the job in the background until the context
+// is cancelled or Stop is called. Starting a job that is already running does nothing.
+func (job *CronJob) Start(ctx context.Context) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	if job.stop != nil {
+		return
+	}
+	job.stop = make(chan struct{})
+	job.done = make(chan struct{})
+
+	go job.run(ctx, job.stop, job.done)
+}
+
+// Stop stops the scheduling loop and waits until the task in progress, if any,
+// has been sent.
+func (job *CronJob) Stop() {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	if job.stop == nil {
+		return
+	}
+	close(job.stop)
+	<-job.done
+	job.stop = nil
+	job.done = nil
+}
+
+func (job *CronJob) run(ctx context.Context, stop, done chan struct{}) {
+	defer close(done)
+
+	for {
+		next := job.schedule.Next(time.Now())
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+			job.fire(ctx, next)
+		case <-stop:
+			timer.Stop()
+			return
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+func (job *CronJob) fire(ctx context.Context, tick time.Time) {
+	key := "delay-cron:" + job.f.key + ":" + strconv.FormatInt(tick.Unix(), 10)
+	logger := log.WithFields(log.Fields{
+		"function": job.f.key,
+		"queue":    job.queue.name,
+		"tick":     tick.Format(time.RFC3339),
+	})
+
+	if job.locker != nil {
+		acquired, err := job.locker.Acquire(ctx, key, job.schedule.Next(tick).Sub(tick))
+		if err != nil {
+			logger.WithField("error", err.Error()).Error("Cannot acquire the cron lock")
+			return
+		}
+		if !acquired {
+			return
+		}
+	}
+
+	// The key of the tick avoids discarding the task as a duplicate of the previous one.
+	args := append([]interface{}{WithIdempotencyKey(key)}, job.args...)
+	if err := job.f.Call(ctx, job.queue, args...); err != nil {
+		logger.WithField("error", err.Error()).Error("Cannot send the cron task")
+	}
+}
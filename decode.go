@@ -0,0 +1,61 @@
+package delay
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	pb "github.com/altipla-consulting/delay/queues"
+)
+
+// ErrOtherFunction is returned by DecodeTask when the task was built by a different
+// function.
+var ErrOtherFunction = errors.New("delay: task of other function")
+
+// DecodeTask decodes the arguments of a task built by the function, converted to
+// the types of its parameters and excluding the context. It is designed to inspect
+// the tasks sent in the unit tests.
+func (f *Function) DecodeTask(task *pb.SendTask) ([]interface{}, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	payload, err := decryptPayload(f.encryptionKeys, task.Payload)
+	if err != nil {
+		return nil, err
+	}
+	codec, inv, err := decodePayload(payload)
+	if err != nil {
+		return nil, fmt.Errorf("delay: cannot decode call: %w", err)
+	}
+	if inv.Key != f.key {
+		return nil, ErrOtherFunction
+	}
+
+	ft := f.fv.Type()
+	args := make([]interface{}, 0, len(inv.Args))
+	for i, arg := range inv.Args {
+		at := argType(ft, i+1)
+		if arg == nil {
+			args = append(args, reflect.Zero(at).Interface())
+			continue
+		}
+		v, err := convertArg(codec, arg, at)
+		if err != nil {
+			return nil, fmt.Errorf("delay: cannot decode argument %d: %w", i+1, err)
+		}
+		args = append(args, v.Interface())
+	}
+
+	return args, nil
+}
+
+// argType returns the type of the nth argument of the function, that may be part
+// of the variadic parameter.
+func argType(ft reflect.Type, n int) reflect.Type {
+	if !ft.IsVariadic() || n < ft.NumIn()-1 {
+		return ft.In(n)
+	}
+
+	return ft.In(ft.NumIn() - 1).Elem()
+}
@@ -0,0 +1,120 @@
+package delay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// ErrDuplicate is returned when building a task with the same arguments as other
+// one built recently by a function registered with WithDeduplication.
+var ErrDuplicate = errors.New("delay: duplicated task")
+
+// DeduplicationStore saves the fingerprints of the tasks to detect duplicates
+// between multiple instances of the application.
+type DeduplicationStore interface {
+	// SetIfAbsent saves the fingerprint during ttl. It returns false if it was
+	// already saved before.
+	SetIfAbsent(ctx context.Context, fingerprint string, ttl time.Duration) (bool, error)
+}
+
+type deduplicator struct {
+	window time.Duration
+	store  DeduplicationStore
+
+	// Expiration of the fingerprints seen locally.
+	seen      sync.Map
+	lastSweep int64
+}
+
+// WithDeduplication discards the tasks of the function with the same arguments
+// as other task built during the window. Task() and Call() return ErrDuplicate
+// instead of building them again.
+func WithDeduplication(window time.Duration) FuncOption {
+	return func(f *Function) {
+		if f.dedup == nil {
+			f.dedup = new(deduplicator)
+		}
+		f.dedup.window = window
+	}
+}
+
+// WithDeduplicationStore shares the fingerprints of WithDeduplication through
+// the store to detect duplicates built by other instances of the application.
+func WithDeduplicationStore(store DeduplicationStore) FuncOption {
+	return func(f *Function) {
+		if f.dedup == nil {
+			f.dedup = new(deduplicator)
+		}
+		f.dedup.store = store
+	}
+}
+
+func (d *deduplicator) check(fingerprint string) error {
+	if d.window <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	d.sweep(now)
+
+	expiry := now.Add(d.window)
+	if prev, loaded := d.seen.LoadOrStore(fingerprint, expiry); loaded {
+		if prev.(time.Time).After(now) {
+			return ErrDuplicate
+		}
+		d.seen.Store(fingerprint, expiry)
+	}
+
+	if d.store != nil {
+		ok, err := d.store.SetIfAbsent(context.Background(), fingerprint, d.window)
+		if err != nil {
+			// Allow the caller to try again building the same task.
+			d.seen.Delete(fingerprint)
+			return fmt.Errorf("delay: cannot check duplicated task: %w", err)
+		}
+		if !ok {
+			return ErrDuplicate
+		}
+	}
+
+	return nil
+}
+
+// sweep removes the expired fingerprints at most once each window.
+func (d *deduplicator) sweep(now time.Time) {
+	last := atomic.LoadInt64(&d.lastSweep)
+	if now.UnixNano()-last < int64(d.window) || !atomic.CompareAndSwapInt64(&d.lastSweep, last, now.UnixNano()) {
+		return
+	}
+
+	d.seen.Range(func(key, value interface{}) bool {
+		if value.(time.Time).Before(now) {
+			d.seen.Delete(key)
+		}
+		return true
+	})
+}
+
+type redisDeduplicationStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisDeduplicationStore saves the fingerprints of the tasks in Redis. Keys
+// are prefixed with "delay-dedup:".
+func NewRedisDeduplicationStore(client *redis.Client) DeduplicationStore {
+	return &redisDeduplicationStore{
+		client: client,
+		prefix: "delay-dedup:",
+	}
+}
+
+func (store *redisDeduplicationStore) SetIfAbsent(ctx context.Context, fingerprint string, ttl time.Duration) (bool, error) {
+	return store.client.WithContext(ctx).SetNX(store.prefix+fingerprint, 1, ttl).Result()
+}
@@ -0,0 +1,92 @@
+package delay
+
+import (
+	"reflect"
+)
+
+type visitKey struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+// deepCopy returns a copy of the value that does not share any pointer, slice or
+// map with the original. Unexported fields of structs are copied shallowly because
+// they cannot be set through reflection.
+func deepCopy(v reflect.Value) reflect.Value {
+	return copyValue(v, make(map[visitKey]reflect.Value))
+}
+
+func copyValue(v reflect.Value, visited map[visitKey]reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		key := visitKey{v.Pointer(), v.Type()}
+		if cp, ok := visited[key]; ok {
+			return cp
+		}
+		cp := reflect.New(v.Type().Elem())
+		visited[key] = cp
+		cp.Elem().Set(copyValue(v.Elem(), visited))
+		return cp
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		key := visitKey{v.Pointer(), v.Type()}
+		if cp, ok := visited[key]; ok && cp.Len() == v.Len() {
+			return cp
+		}
+		cp := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		visited[key] = cp
+		for i := 0; i < v.Len(); i++ {
+			cp.Index(i).Set(copyValue(v.Index(i), visited))
+		}
+		return cp
+
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		key := visitKey{v.Pointer(), v.Type()}
+		if cp, ok := visited[key]; ok {
+			return cp
+		}
+		cp := reflect.MakeMapWithSize(v.Type(), v.Len())
+		visited[key] = cp
+		iter := v.MapRange()
+		for iter.Next() {
+			cp.SetMapIndex(copyValue(iter.Key(), visited), copyValue(iter.Value(), visited))
+		}
+		return cp
+
+	case reflect.Array:
+		cp := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			cp.Index(i).Set(copyValue(v.Index(i), visited))
+		}
+		return cp
+
+	case reflect.Struct:
+		cp := reflect.New(v.Type()).Elem()
+		cp.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if cp.Field(i).CanSet() {
+				cp.Field(i).Set(copyValue(v.Field(i), visited))
+			}
+		}
+		return cp
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		cp := reflect.New(v.Type()).Elem()
+		cp.Set(copyValue(v.Elem(), visited))
+		return cp
+	}
+
+	return v
+}
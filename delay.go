@@ -1,29 +1,26 @@
 package delay
 
 import (
-	"bytes"
 	"context"
-	"encoding/gob"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"io"
+	"math"
+	"math/rand"
 	"reflect"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/altipla-consulting/datetime"
-	altiplaerrors "github.com/altipla-consulting/errors"
-	"github.com/altipla-consulting/sentry"
-	"github.com/golang/protobuf/proto"
-	log "github.com/sirupsen/logrus"
-	"golang.org/x/sync/errgroup"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/duration"
+	"golang.org/x/time/rate"
 
 	pb "github.com/altipla-consulting/delay/queues"
 )
 
 var (
-	// registry of all delayed functions
-	funcs = make(map[string]*Function)
-
 	// precomputed types
 	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
 	errorType   = reflect.TypeOf((*error)(nil)).Elem()
@@ -31,65 +28,417 @@ var (
 
 // Function is a stored task implementation.
 type Function struct {
-	fv  reflect.Value // Kind() == reflect.Func
-	key string
-	err error
+	fv          reflect.Value // Kind() == reflect.Func
+	registry    *Registry
+	key         string
+	shortKey    string
+	err         error
+	timeout     time.Duration
+	retryPolicy *RetryPolicy
+	retryIf     func(err error) bool
+	deadLetter  *QueueSpec
+	codec       Codec
+	limiter     *rate.Limiter
+	distLimiter *distributedLimiter
+	shadow      *shadowFunction
+	dedup       *deduplicator
+	resultStore ResultStore
+	sanitizer   ArgSanitizer
+	cost        float64
+
+	errorHandler    ErrorHandler
+	middlewares     []HandlerMiddleware
+	timeoutHandlers []func(ctx context.Context, args []interface{})
+	panicHandlers   []func(ctx context.Context, recovered interface{}, args []interface{})
+
+	compression          CompressionAlgorithm
+	compressionThreshold int
+	encryptionKeys       [][]byte
+
+	schemaVersion int
+	migrations    map[int]MigrationFn
+}
+
+// FuncOption configures a function when registering it.
+type FuncOption func(f *Function)
+
+// WithTimeout changes the maximum time the function can run before its context
+// is cancelled. If not specified the default timeout of the listener will be used.
+func WithTimeout(d time.Duration) FuncOption {
+	return func(f *Function) {
+		f.timeout = d
+	}
+}
+
+// RetryPolicy controls how a failed task is sent again to the queue by the listener.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of times a task will be executed, including
+	// the first one, before giving up.
+	MaxAttempts int32
+
+	// BaseDelay is the time the listener waits before the first retry.
+	BaseDelay time.Duration
+
+	// Multiplier increases the delay between consecutive retries. If zero it
+	// defaults to 2, doubling the delay each time.
+	Multiplier float64
+
+	// Jitter is the fraction (between 0 and 1) of random variation applied to each
+	// delay to avoid retrying lots of tasks at the same time.
+	Jitter float64
+}
+
+func (policy RetryPolicy) backoff(retry int32) time.Duration {
+	multiplier := policy.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+	delay := float64(policy.BaseDelay) * math.Pow(multiplier, float64(retry))
+	if policy.Jitter > 0 {
+		delay += delay * policy.Jitter * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(delay)
+}
+
+// WithRetryPolicy makes the listener send again the failed tasks of the function
+// with an exponential backoff instead of relying on the queue server retries.
+func WithRetryPolicy(policy RetryPolicy) FuncOption {
+	return func(f *Function) {
+		f.retryPolicy = &policy
+	}
+}
+
+// WithRetryIf retries the failed tasks of the function only if the predicate returns
+// true for their error. The rest of errors are handled like the ones marked with
+// NonRetryable. IsTransientError can be used as the predicate to retry only the
+// network errors and timeouts.
+func WithRetryIf(fn func(err error) bool) FuncOption {
+	return func(f *Function) {
+		f.retryIf = fn
+	}
 }
 
-// Func builds and registers a new task implementation.
-func Func(key string, i interface{}) *Function {
-	f := &Function{
-		fv: reflect.ValueOf(i),
+// isRetryable returns true if the task that failed with the error should be retried.
+func (f *Function) isRetryable(err error) bool {
+	if IsNonRetryable(err) {
+		return false
 	}
 
+	return f.retryIf == nil || f.retryIf(err)
+}
+
+// WithFunctionDeadLetterQueue sends the tasks of the function that exhaust all
+// their retry attempts to the queue. It overrides the dead-letter queue of the listener.
+func WithFunctionDeadLetterQueue(dlq QueueSpec) FuncOption {
+	return func(f *Function) {
+		f.deadLetter = &dlq
+	}
+}
+
+// WithCodec changes the serialization of the tasks sent to the function. By default
+// tasks are serialized with GobCodec. Received tasks are always decoded with the
+// codec that serialized them, so the codec of a function can change safely.
+func WithCodec(codec Codec) FuncOption {
+	return func(f *Function) {
+		f.codec = codec
+	}
+}
+
+// WithRateLimit limits the executions of the function in this process to rps
+// tasks per second, allowing bursts of up to burst tasks. Tasks that cannot run
+// before their timeout fail and are retried later.
+func WithRateLimit(rps float64, burst int) FuncOption {
+	return func(f *Function) {
+		f.limiter = rate.NewLimiter(rate.Limit(rps), burst)
+	}
+}
+
+// Func builds and registers a new task implementation. The full key of the function
+// contains the path of the file that calls Func, so moving the call to other file
+// changes it and the pending tasks are not found anymore. New code should prefer
+// FuncWithKey.
+func Func(key string, i interface{}, opts ...FuncOption) *Function {
 	// Derive unique, somewhat stable key for this func.
 	_, file, _, _ := runtime.Caller(1)
-	f.key = file + ":" + key
-
-	t := f.fv.Type()
-	if t.Kind() != reflect.Func {
-		f.err = fmt.Errorf("delay: not a function")
-		return f
-	}
-	if t.NumIn() == 0 || t.In(0) != contextType {
-		f.err = fmt.Errorf("delay: first argument must be context.Context")
-		return f
-	}
-
-	// Register the function's arguments with the gob package.
-	// This is required because they are marshaled inside a []interface{}.
-	// gob.Register only expects to be called during initialization;
-	// that's fine because this function expects the same.
-	for i := 0; i < t.NumIn(); i++ {
-		// Only concrete types may be registered. If the argument has
-		// interface type, the client is resposible for registering the
-		// concrete types it will hold.
-		if t.In(i).Kind() == reflect.Interface {
-			continue
+
+	return defaultRegistry.register(file+":"+key, key, fmt.Sprintf("%s in %s", key, file), i, opts)
+}
+
+// FuncWithKey builds and registers a new task implementation with an explicit full
+// key. The key should be unique and cannot contain ":", reserved for the keys
+// generated by Func.
+func FuncWithKey(key string, i interface{}, opts ...FuncOption) *Function {
+	return defaultRegistry.FuncWithKey(key, i, opts...)
+}
+
+// Clone registers a new function with a different key that calls the same Go function.
+// It starts with the options and the middlewares of the original function and
+// applies the overrides on top of them. The rate limit of the clone is independent
+// of the original and it belongs to the same registry.
+//
+// The clone of a function registered with FuncWithKey or inside a group replaces
+// the last part of the original key with newKey, otherwise the key includes the
+// path of the source file calling Clone like Func.
+func (f *Function) Clone(newKey string, overrides ...FuncOption) *Function {
+	_, file, _, _ := runtime.Caller(1)
+
+	inherit := func(clone *Function) {
+		clone.timeout = f.timeout
+		clone.retryPolicy = f.retryPolicy
+		clone.retryIf = f.retryIf
+		clone.deadLetter = f.deadLetter
+		clone.errorHandler = f.errorHandler
+		clone.codec = f.codec
+		clone.compression = f.compression
+		clone.compressionThreshold = f.compressionThreshold
+		clone.encryptionKeys = f.encryptionKeys
+		clone.schemaVersion = f.schemaVersion
+		clone.migrations = f.migrations
+		if f.limiter != nil {
+			clone.limiter = rate.NewLimiter(f.limiter.Limit(), f.limiter.Burst())
+		}
+		clone.distLimiter = f.distLimiter
+		clone.resultStore = f.resultStore
+		clone.sanitizer = f.sanitizer
+		clone.cost = f.cost
+		if f.dedup != nil {
+			clone.dedup = &deduplicator{
+				window: f.dedup.window,
+				store:  f.dedup.store,
+			}
+		}
+		if f.shadow != nil {
+			shadow := *f.shadow
+			clone.shadow = &shadow
+		}
+		clone.middlewares = append([]HandlerMiddleware(nil), f.middlewares...)
+		clone.timeoutHandlers = append(([]func(ctx context.Context, args []interface{}))(nil), f.timeoutHandlers...)
+		clone.panicHandlers = append(([]func(ctx context.Context, recovered interface{}, args []interface{}))(nil), f.panicHandlers...)
+	}
+	opts := append([]FuncOption{inherit}, overrides...)
+
+	// Explicit keys never contain ":", unlike the keys generated by Func.
+	key, description := file+":"+newKey, fmt.Sprintf("%s in %s", newKey, file)
+	if full := baseKey(f.key); !strings.Contains(full, ":") {
+		key = strings.TrimSuffix(full, f.shortKey) + newKey
+		description = key
+	}
+
+	return f.registry.register(key, newKey, description, f.fv.Interface(), opts)
+}
+
+// Key returns the full key the function was registered with, including the path
+// of the source file or the prefix of its group. Tasks reference the function
+// with this key.
+func (f *Function) Key() string {
+	return f.key
+}
+
+// ShortKey returns the key the function was registered with, without the path of
+// the source file or the prefix of its group.
+func (f *Function) ShortKey() string {
+	return f.shortKey
+}
+
+// FuncName returns the name of the Go function that runs the tasks.
+func (f *Function) FuncName() string {
+	if f.fv.Kind() != reflect.Func {
+		return ""
+	}
+
+	return runtime.FuncForPC(f.fv.Pointer()).Name()
+}
+
+// NumIn returns the number of arguments of the function, excluding the context.
+func (f *Function) NumIn() int {
+	if f.fv.Kind() != reflect.Func || f.fv.Type().NumIn() == 0 {
+		return 0
+	}
+
+	return f.fv.Type().NumIn() - 1
+}
+
+// ArgType returns the type of the argument i of the function, excluding the context.
+// The last argument of variadic functions is a slice. It returns nil if the argument
+// does not exist.
+func (f *Function) ArgType(i int) reflect.Type {
+	if i < 0 || i >= f.NumIn() {
+		return nil
+	}
+
+	return f.fv.Type().In(i + 1)
+}
+
+// IsVariadic returns true if the last argument of the function is variadic.
+func (f *Function) IsVariadic() bool {
+	if f.fv.Kind() != reflect.Func {
+		return false
+	}
+
+	return f.fv.Type().IsVariadic()
+}
+
+// Unregister removes the function from its registry, allowing to register other
+// function with the same key. Tasks received for it will fail afterwards. It is
+// designed to clean up the functions registered inside tests.
+func (f *Function) Unregister() error {
+	return f.registry.unregister(f)
+}
+
+// Priority of a task inside its queue. Tasks with higher priority are executed
+// first by the listeners that enable WithPriorityOrdering.
+type Priority int32
+
+// Predefined priorities of the tasks.
+const (
+	PriorityHigh   Priority = 1
+	PriorityNormal Priority = 0
+	PriorityLow    Priority = -1
+)
+
+// TaskOption configures a task when building it. Options can be passed to Task()
+// or Call() mixed with the arguments of the function.
+type TaskOption func(task *pb.SendTask)
+
+// WithPriority changes the priority of the task. By default all tasks have PriorityNormal.
+func WithPriority(p Priority) TaskOption {
+	return func(task *pb.SendTask) {
+		task.Priority = int32(p)
+	}
+}
+
+// WithIdempotencyKey changes the key the server uses to discard duplicates of
+// the task sent inside its deduplication window. By default the key is computed
+// from the function and its arguments. GobCodec does not encode maps in a stable
+// order, so tasks with maps in their arguments should use a custom key.
+func WithIdempotencyKey(key string) TaskOption {
+	return func(task *pb.SendTask) {
+		task.DeduplicationKey = key
+	}
+}
+
+// WithDeduplicationID is an alias of WithIdempotencyKey. The deduplication key of
+// the tasks also protects the retries of the requests that send them.
+func WithDeduplicationID(id string) TaskOption {
+	return WithIdempotencyKey(id)
+}
+
+// WithHeaders adds the headers to the task. The handlers can read them from the
+// context with HeaderFromContext and HeadersFromContext.
+func WithHeaders(headers map[string]string) TaskOption {
+	return func(task *pb.SendTask) {
+		if task.Headers == nil {
+			task.Headers = make(map[string]string, len(headers))
+		}
+		for k, v := range headers {
+			task.Headers[k] = v
 		}
-		gob.Register(reflect.Zero(t.In(i)).Interface())
 	}
+}
 
-	if old := funcs[f.key]; old != nil {
-		old.err = fmt.Errorf("delay: multiple functions registered for %s in %s", key, file)
+// WithHeader adds a single header to the task.
+func WithHeader(key, value string) TaskOption {
+	return WithHeaders(map[string]string{key: value})
+}
+
+// WithTTL discards the task if it cannot start running before the duration
+// passes. The duration is counted from the ETA of the task, if any, or from the
+// moment it is sent to the queue.
+func WithTTL(d time.Duration) TaskOption {
+	return func(task *pb.SendTask) {
+		task.Ttl = ptypes.DurationProto(d)
 	}
-	funcs[f.key] = f
+}
 
-	return f
+// taskExpiry returns the time when the task expires if it has a TTL.
+func taskExpiry(task *pb.Task) (time.Time, bool) {
+	if task.Ttl == nil {
+		return time.Time{}, false
+	}
+	ttl, err := ptypes.Duration(task.Ttl)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	base := datetime.ParseTimestamp(task.MinEta)
+	if base.IsZero() {
+		base = datetime.ParseTimestamp(task.Created)
+	}
+
+	return base.Add(ttl), true
+}
+
+// retryTTL adapts the TTL of a task that will be sent again at the ETA to keep
+// the original expiry.
+func retryTTL(task *pb.Task, eta time.Time) *duration.Duration {
+	expiry, ok := taskExpiry(task)
+	if !ok {
+		return nil
+	}
+
+	return ptypes.DurationProto(expiry.Sub(eta))
+}
+
+// Prefix of the deduplication keys computed from the content of the task.
+const computedKeyPrefix = "sha256:"
+
+func deduplicationKey(key string, payload []byte) string {
+	h := sha256.New()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write(payload)
+
+	return computedKeyPrefix + hex.EncodeToString(h.Sum(nil))
 }
 
 type invocation struct {
-	Key  string
-	Args []interface{}
+	Key     string
+	Args    []interface{}
+	Version int
 }
 
 // Task builds a task invocation to the function. You can later send the task
 // in batches using queue.SendTasks() or directly invoke Call() to make both things
 // at the same time.
+//
+// Any TaskOption found in the arguments will be applied to the task instead of
+// sending it to the function.
+//
+// If the function was registered with WithDeduplication it returns ErrDuplicate
+// when the same arguments were sent recently.
 func (f *Function) Task(args ...interface{}) (*pb.SendTask, error) {
+	task, fingerprint, err := f.buildTask(args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.dedup != nil {
+		if err := f.dedup.check(fingerprint); err != nil {
+			return nil, err
+		}
+	}
+
+	return task, nil
+}
+
+// buildTask checks the arguments and builds the task. It also returns the fingerprint
+// of the payload before encrypting it to detect the duplicates.
+func (f *Function) buildTask(args ...interface{}) (*pb.SendTask, string, error) {
 	if f.err != nil {
-		return nil, f.err
+		return nil, "", f.err
+	}
+
+	var opts []TaskOption
+	var fargs []interface{}
+	for _, arg := range args {
+		if opt, ok := arg.(TaskOption); ok {
+			opts = append(opts, opt)
+			continue
+		}
+		fargs = append(fargs, arg)
 	}
+	args = fargs
 
 	nArgs := len(args) + 1 // +1 for the context.Context
 	ft := f.fv.Type()
@@ -98,10 +447,10 @@ func (f *Function) Task(args ...interface{}) (*pb.SendTask, error) {
 		minArgs--
 	}
 	if nArgs < minArgs {
-		return nil, fmt.Errorf("delay: too few arguments to func: %d < %d", nArgs, minArgs)
+		return nil, "", fmt.Errorf("delay: too few arguments to func: %d < %d", nArgs, minArgs)
 	}
 	if !ft.IsVariadic() && nArgs > minArgs {
-		return nil, fmt.Errorf("delay: too many arguments to func: %d > %d", nArgs, minArgs)
+		return nil, "", fmt.Errorf("delay: too many arguments to func: %d > %d", nArgs, minArgs)
 	}
 
 	// Check arg types.
@@ -124,7 +473,7 @@ func (f *Function) Task(args ...interface{}) (*pb.SendTask, error) {
 			case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
 				continue // may be nil
 			}
-			return nil, fmt.Errorf("delay: argument %d has wrong type: %v is not nilable", i, dt)
+			return nil, "", fmt.Errorf("delay: argument %d has wrong type: %v is not nilable", i, dt)
 		}
 
 		switch at.Kind() {
@@ -138,23 +487,43 @@ func (f *Function) Task(args ...interface{}) (*pb.SendTask, error) {
 		}
 
 		if !at.AssignableTo(dt) {
-			return nil, fmt.Errorf("delay: argument %d has wrong type: %v is not assignable to %v", i, at, dt)
+			return nil, "", fmt.Errorf("delay: argument %d has wrong type: %v is not assignable to %v", i, at, dt)
 		}
 	}
 
 	inv := invocation{
-		Key:  f.key,
-		Args: args,
+		Key:     f.key,
+		Args:    args,
+		Version: f.schemaVersion,
 	}
 
-	buf := new(bytes.Buffer)
-	if err := gob.NewEncoder(buf).Encode(inv); err != nil {
-		return nil, err
+	payload, err := encodePayload(f.codec, inv)
+	if err != nil {
+		return nil, "", err
+	}
+	payload, err = compressPayload(f.compression, f.compressionThreshold, payload)
+	if err != nil {
+		return nil, "", err
+	}
+	encrypted, err := encryptPayload(f.encryptionKeys, payload)
+	if err != nil {
+		return nil, "", err
+	}
+
+	task := &pb.SendTask{
+		Payload: encrypted,
+	}
+	for _, opt := range opts {
+		opt(task)
+	}
+	// Encrypted payloads change each time with the nonce, the fingerprint is computed
+	// from the original one to detect the duplicates.
+	fingerprint := deduplicationKey(f.key, payload)
+	if task.DeduplicationKey == "" {
+		task.DeduplicationKey = fingerprint
 	}
 
-	return &pb.SendTask{
-		Payload: buf.Bytes(),
-	}, nil
+	return task, fingerprint, nil
 }
 
 // Call builds a task invocation and directly sends it individually to the queue.
@@ -172,205 +541,54 @@ func (f *Function) Call(ctx context.Context, queue QueueSpec, args ...interface{
 	return queue.SendTasks(ctx, []*pb.SendTask{task})
 }
 
-// Listener is a background goroutine that handles messages from the queues
-// and run them in other controlled goroutines.
-type Listener struct {
-	sentryClient *sentry.Client
-}
-
-// NewListener prepares a new background goroutine to handle messages.
-func NewListener(sentryDSN string) *Listener {
-	lis := new(Listener)
-	if sentryDSN != "" {
-		lis.sentryClient = sentry.NewClient(sentryDSN)
+// MustTask is like Task but panics if the task cannot be built. It simplifies the
+// initialization code where the error would be fatal anyway.
+func (f *Function) MustTask(args ...interface{}) *pb.SendTask {
+	task, err := f.Task(args...)
+	if err != nil {
+		panic(err)
 	}
 
-	return lis
+	return task
 }
 
-// Handle opens a listen connection to the queue and starts receiving tasks from it
-// in the background.
-func (lis *Listener) Handle(queue QueueSpec) {
-	go func() {
-		for {
-			if err := lis.listenQueue(queue); err != nil {
-				log.WithFields(log.Fields{
-					"error":   err.Error(),
-					"project": queue.conn.project,
-					"queue":   queue.name,
-				}).Error("Error listening to queue, retrying in 15 seconds")
-			}
-			time.Sleep(15 * time.Second)
-		}
-	}()
-}
-
-func (lis *Listener) listenQueue(queue QueueSpec) error {
-	group, ctx := errgroup.WithContext(context.Background())
-
-	if queue.conn.redisClient != nil {
-		group.Go(func() error {
-			pubsub := queue.conn.redisClient.Subscribe(queue.name)
-
-			var i int64
-			for msg := range pubsub.Channel() {
-				buf := proto.NewBuffer([]byte(msg.Payload))
-				for {
-					sendTask := new(pb.SendTask)
-					if err := buf.DecodeMessage(sendTask); err != nil {
-						if err == io.EOF {
-							break
-						}
-
-						return fmt.Errorf("delay: cannot decode incoming task: %v", err)
-					}
-
-					i++
-					task := &pb.Task{
-						Code:    fmt.Sprintf("sim-%d", i),
-						Payload: sendTask.Payload,
-						Created: datetime.SerializeTimestamp(time.Now()),
-						Retry:   0,
-						Project: queue.conn.project,
-						MinEta:  sendTask.MinEta,
-					}
-
-					log.WithFields(log.Fields{
-						"project": task.Project,
-						"queue":   task.QueueName,
-						"task":    task.Code,
-					}).Debug("Task received")
-
-					if err := handleTask(ctx, task); err != nil {
-						log.WithFields(log.Fields{
-							"error":   err.Error(),
-							"details": altiplaerrors.Details(err),
-							"project": task.Project,
-							"queue":   task.QueueName,
-							"task":    task.Code,
-						}).Error("Task handler failed")
-					}
-				}
-			}
-
-			return nil
-		})
-	} else {
-		stream, err := queue.conn.queuesClient.Listen(ctx)
-		if err != nil {
-			return fmt.Errorf("delay: cannot listen to the queue: %v", err)
-		}
-
-		initial := &pb.ListenRequest{
-			Request: &pb.ListenRequest_Initial{
-				Initial: &pb.ListenInitial{
-					Project:   queue.conn.project,
-					QueueName: queue.name,
-				},
-			},
-		}
-		if err := stream.Send(initial); err != nil {
-			return fmt.Errorf("delay: cannot send initial connection info: %v", err)
-		}
-
-		group.Go(func() error {
-			for {
-				reply, err := stream.Recv()
-				if err != nil {
-					return fmt.Errorf("delay: cannot receive tasks: %v", err)
-				}
-
-				group.Go(func() error {
-					log.WithFields(log.Fields{
-						"project": reply.Task.Project,
-						"queue":   reply.Task.QueueName,
-						"task":    reply.Task.Code,
-					}).Debug("Task received")
-
-					var failed bool
-					if err := handleTask(ctx, reply.Task); err != nil {
-						failed = true
-
-						log.WithFields(log.Fields{
-							"error":   err.Error(),
-							"details": altiplaerrors.Details(err),
-							"project": reply.Task.Project,
-							"queue":   reply.Task.QueueName,
-							"task":    reply.Task.Code,
-						}).Error("Task handler failed")
-
-						if lis.sentryClient != nil {
-							lis.sentryClient.ReportInternal(ctx, err)
-						}
-					}
-
-					req := &pb.ListenRequest{
-						Request: &pb.ListenRequest_Ack{
-							Ack: &pb.Ack{
-								Code:    reply.Task.Code,
-								Success: !failed,
-							},
-						},
-					}
-					if err := stream.Send(req); err != nil {
-						return fmt.Errorf("delay: cannot ack task: %v", err)
-					}
-
-					return nil
-				})
-			}
-		})
-	}
-
-	if err := group.Wait(); err != nil {
-		return fmt.Errorf("delay: error closing the background queue goroutines: %v", err)
+// MustCall is like Call but panics if the task cannot be built or sent. It simplifies
+// the initialization code where the error would be fatal anyway.
+func (f *Function) MustCall(ctx context.Context, queue QueueSpec, args ...interface{}) {
+	if err := f.Call(ctx, queue, args...); err != nil {
+		panic(err)
 	}
-
-	return nil
 }
 
-func handleTask(ctx context.Context, task *pb.Task) error {
-	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
-	defer cancel()
+// TaskAfter builds a task invocation to the function that will not run until
+// the duration has passed.
+func (f *Function) TaskAfter(d time.Duration, args ...interface{}) (*pb.SendTask, error) {
+	return f.taskAt(time.Now().Add(d), args...)
+}
 
-	r := bytes.NewReader(task.Payload)
-	var inv invocation
-	if err := gob.NewDecoder(r).Decode(&inv); err != nil {
-		return fmt.Errorf("delay: cannot decode call: %v", err)
+func (f *Function) taskAt(t time.Time, args ...interface{}) (*pb.SendTask, error) {
+	task, err := f.Task(args...)
+	if err != nil {
+		return nil, err
 	}
+	task.MinEta = datetime.SerializeTimestamp(t)
 
-	f := funcs[inv.Key]
-	if f == nil {
-		return fmt.Errorf("delay: no func with key %q found", inv.Key)
-	}
+	return task, nil
+}
 
-	ft := f.fv.Type()
-	in := []reflect.Value{reflect.ValueOf(ctx)}
-	for _, arg := range inv.Args {
-		var v reflect.Value
-		if arg != nil {
-			v = reflect.ValueOf(arg)
-		} else {
-			// Task was passed a nil argument, so we must construct
-			// the zero value for the argument here.
-			n := len(in) // we're constructing the nth argument
-			var at reflect.Type
-			if !ft.IsVariadic() || n < ft.NumIn()-1 {
-				at = ft.In(n)
-			} else {
-				at = ft.In(ft.NumIn() - 1).Elem()
-			}
-			v = reflect.Zero(at)
-		}
-		in = append(in, v)
-	}
-	out := f.fv.Call(in)
+// CallAfter builds a task invocation and sends it to the queue to run after
+// the duration has passed.
+func (f *Function) CallAfter(ctx context.Context, queue QueueSpec, d time.Duration, args ...interface{}) error {
+	return f.CallAt(ctx, queue, time.Now().Add(d), args...)
+}
 
-	if n := ft.NumOut(); n > 0 && ft.Out(n-1) == errorType {
-		if errv := out[n-1]; !errv.IsNil() {
-			return fmt.Errorf("delay: handler failed: %v", errv.Interface().(error))
-		}
+// CallAt builds a task invocation and sends it to the queue to run at the time
+// or later.
+func (f *Function) CallAt(ctx context.Context, queue QueueSpec, t time.Time, args ...interface{}) error {
+	task, err := f.taskAt(t, args...)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return queue.SendTasks(ctx, []*pb.SendTask{task})
 }
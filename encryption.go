@@ -0,0 +1,97 @@
+package delay
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+)
+
+// Discriminator written before the encrypted payloads. It uses a reserved codec
+// identifier like the compressed payloads.
+const payloadEncrypted byte = 5
+
+// WithEncryption encrypts the payload of the tasks of the function with AES-256-GCM
+// using the 32 bytes key. The listeners decrypt the tasks with the keys of all the
+// functions of their registry, so the receiving programs should register the
+// function with the same key.
+func WithEncryption(key []byte) FuncOption {
+	return WithEncryptionKeys([][]byte{key})
+}
+
+// WithEncryptionKeys is like WithEncryption but accepts multiple keys to rotate them.
+// The first key encrypts the new tasks and all of them are tried to decrypt the
+// ones received from the queues.
+func WithEncryptionKeys(keys [][]byte) FuncOption {
+	return func(f *Function) {
+		f.encryptionKeys = keys
+	}
+}
+
+func checkEncryptionKeys(keys [][]byte) error {
+	for _, key := range keys {
+		if len(key) != 32 {
+			return fmt.Errorf("delay: encryption keys should have 32 bytes: %d", len(key))
+		}
+	}
+
+	return nil
+}
+
+func encryptPayload(keys [][]byte, payload []byte) ([]byte, error) {
+	if len(keys) == 0 {
+		return payload, nil
+	}
+
+	aead, err := newAEAD(keys[0])
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("delay: cannot generate nonce: %w", err)
+	}
+
+	encrypted := append([]byte{payloadEncrypted}, nonce...)
+	return aead.Seal(encrypted, nonce, payload, nil), nil
+}
+
+// decryptPayload returns the original payload if it was encrypted with any of the keys.
+func decryptPayload(keys [][]byte, payload []byte) ([]byte, error) {
+	if len(payload) == 0 || payload[0] != payloadEncrypted {
+		return payload, nil
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("delay: encrypted payload without decryption keys")
+	}
+
+	for _, key := range keys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(payload) < 1+aead.NonceSize() {
+			return nil, fmt.Errorf("delay: encrypted payload too short")
+		}
+		nonce, ciphertext := payload[1:1+aead.NonceSize()], payload[1+aead.NonceSize():]
+		if decrypted, err := aead.Open(nil, nonce, ciphertext, nil); err == nil {
+			return decrypted, nil
+		}
+	}
+
+	return nil, fmt.Errorf("delay: cannot decrypt payload with any of the keys")
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("delay: invalid encryption key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("delay: invalid encryption key: %w", err)
+	}
+
+	return aead, nil
+}
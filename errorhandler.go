@@ -0,0 +1,83 @@
+package delay
+
+import (
+	"context"
+	"sync"
+
+	pb "github.com/altipla-consulting/delay/queues"
+)
+
+// ErrorHandler receives the tasks that fail to apply custom policies to their
+// errors, like paging someone or only logging them.
+type ErrorHandler func(ctx context.Context, task *pb.Task, err error)
+
+var (
+	globalErrorHandlerMu sync.RWMutex
+	globalErrorHandler   ErrorHandler
+)
+
+// SetErrorHandler changes the error handler of the tasks that fail when neither
+// the function, the queue nor the listener have their own one.
+func SetErrorHandler(fn ErrorHandler) {
+	globalErrorHandlerMu.Lock()
+	defer globalErrorHandlerMu.Unlock()
+
+	globalErrorHandler = fn
+}
+
+// WithFunctionErrorHandler changes the error handler of the tasks of the function. It
+// is used instead of the ones of the queue, the listener and the global one.
+func WithFunctionErrorHandler(fn ErrorHandler) FuncOption {
+	return func(f *Function) {
+		f.errorHandler = fn
+	}
+}
+
+// WithErrorHandler changes the error handler of the tasks of all the queues of the
+// listener that have no handler of their own.
+func WithErrorHandler(fn ErrorHandler) ListenerOption {
+	return func(lis *Listener) {
+		lis.errorHandler = fn
+	}
+}
+
+// queueOptions are stored in a pointer to keep QueueSpec comparable.
+type queueOptions struct {
+	errorHandler ErrorHandler
+	tagFilter    func(tags []string) bool
+}
+
+// WithErrorHandler returns a reference to the same queue that sends the errors of
+// its tasks to the handler, unless the function has a handler of its own.
+func (queue QueueSpec) WithErrorHandler(fn ErrorHandler) QueueSpec {
+	options := new(queueOptions)
+	if queue.options != nil {
+		*options = *queue.options
+	}
+	options.errorHandler = fn
+	queue.options = options
+
+	return queue
+}
+
+// handleError calls the first error handler configured for the failed task between
+// the ones of the function, the queue, the listener and the global one.
+func (lis *Listener) handleError(ctx context.Context, queue QueueSpec, f *Function, task *pb.Task, err error) {
+	var handler ErrorHandler
+	switch {
+	case f != nil && f.errorHandler != nil:
+		handler = f.errorHandler
+	case queue.options != nil && queue.options.errorHandler != nil:
+		handler = queue.options.errorHandler
+	case lis.errorHandler != nil:
+		handler = lis.errorHandler
+	default:
+		globalErrorHandlerMu.RLock()
+		handler = globalErrorHandler
+		globalErrorHandlerMu.RUnlock()
+	}
+
+	if handler != nil {
+		handler(ctx, task, err)
+	}
+}
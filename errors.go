@@ -0,0 +1,91 @@
+package delay
+
+import (
+	"context"
+	"io"
+	"net"
+	"syscall"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type nonRetryableError struct {
+	err error
+}
+
+func (e *nonRetryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *nonRetryableError) Unwrap() error {
+	return e.err
+}
+
+// NonRetryable marks an error returned by a function to avoid retrying the task.
+// Non retryable tasks are sent directly to the dead-letter queue, if any, and
+// they are not retried by the queue server either.
+func NonRetryable(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &nonRetryableError{err}
+}
+
+// IsNonRetryable returns true if the error or any of the errors it wraps was
+// marked with NonRetryable. It understands both the standard wrapping of errors
+// and the wrapping of github.com/altipla-consulting/errors.
+func IsNonRetryable(err error) bool {
+	return matchError(err, func(err error) bool {
+		_, ok := err.(*nonRetryableError)
+		return ok
+	})
+}
+
+// IsTransientError returns true if the error or any of the errors it wraps is
+// a network error, a timeout or a gRPC status that usually disappears retrying
+// the operation later.
+func IsTransientError(err error) bool {
+	return matchError(err, func(err error) bool {
+		if err == context.DeadlineExceeded || err == io.ErrUnexpectedEOF {
+			return true
+		}
+		if errno, ok := err.(syscall.Errno); ok {
+			return errno == syscall.ECONNRESET || errno == syscall.ECONNREFUSED || errno == syscall.EPIPE
+		}
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return true
+		}
+		if st, ok := status.FromError(err); ok {
+			switch st.Code() {
+			case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+				return true
+			}
+		}
+
+		return false
+	})
+}
+
+// matchError returns true if the error or any of the errors it wraps matches. It
+// understands both the standard wrapping of errors and the wrapping of
+// github.com/altipla-consulting/errors.
+func matchError(err error, match func(err error) bool) bool {
+	for err != nil {
+		if match(err) {
+			return true
+		}
+
+		switch e := err.(type) {
+		case interface{ Unwrap() error }:
+			err = e.Unwrap()
+		case interface{ Underlying() error }:
+			err = e.Underlying()
+		default:
+			return false
+		}
+	}
+
+	return false
+}
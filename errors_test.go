@@ -0,0 +1,148 @@
+package delay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	pb "github.com/altipla-consulting/delay/queues"
+)
+
+type codeError struct {
+	code int
+}
+
+func (err *codeError) Error() string {
+	return fmt.Sprintf("code %d", err.code)
+}
+
+// failingQueuesClient returns err when sending tasks to the queues server.
+type failingQueuesClient struct {
+	pb.QueuesServiceClient
+	err error
+}
+
+func (client failingQueuesClient) SendTasks(ctx context.Context, in *pb.SendTasksRequest, opts ...grpc.CallOption) (*pb.SendTasksReply, error) {
+	return nil, client.err
+}
+
+// runTask sends a task of the function to an in-memory queue and handles it.
+func runTask(t *testing.T, r *Registry, f *Function) error {
+	t.Helper()
+
+	m := NewInMemoryConn("test")
+	if err := f.Call(context.Background(), m.Queue("default")); err != nil {
+		t.Fatal(err)
+	}
+	name, task := m.next()
+
+	lis := NewListener(WithRegistry(r))
+	defer lis.Stop(context.Background())
+	_, err := lis.handleTask(context.Background(), m.Queue(name), task)
+
+	return err
+}
+
+func TestHandleTaskDeadlineExceeded(t *testing.T) {
+	r := NewRegistry()
+	f := r.Func("deadline", func(ctx context.Context) error {
+		<-ctx.Done()
+		return fmt.Errorf("cannot finish: %w", ctx.Err())
+	}, WithTimeout(time.Millisecond))
+
+	err := runTask(t, r, f)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error does not match context.DeadlineExceeded: %v", err)
+	}
+}
+
+func TestHandleTaskTypedError(t *testing.T) {
+	r := NewRegistry()
+	f := r.Func("typed", func(ctx context.Context) error {
+		return fmt.Errorf("cannot finish: %w", &codeError{code: 42})
+	})
+
+	err := runTask(t, r, f)
+	var codeErr *codeError
+	if !errors.As(err, &codeErr) {
+		t.Fatalf("error does not match *codeError: %v", err)
+	}
+	if codeErr.code != 42 {
+		t.Errorf("unexpected code: %d", codeErr.code)
+	}
+}
+
+func TestHandleTaskNonRetryableTypedError(t *testing.T) {
+	r := NewRegistry()
+	f := r.Func("nonretryable", func(ctx context.Context) error {
+		return NonRetryable(&codeError{code: 7})
+	})
+
+	err := runTask(t, r, f)
+	if !IsNonRetryable(err) {
+		t.Errorf("error is not marked as non retryable: %v", err)
+	}
+	var codeErr *codeError
+	if !errors.As(err, &codeErr) {
+		t.Errorf("error does not match *codeError: %v", err)
+	}
+}
+
+func TestSendTasksDeadlineExceeded(t *testing.T) {
+	conn := &Conn{
+		project:      "test",
+		queuesClient: failingQueuesClient{err: fmt.Errorf("rpc: %w", context.DeadlineExceeded)},
+	}
+
+	err := Queue(conn, "default").SendTasks(context.Background(), []*pb.SendTask{{Payload: []byte("foo")}})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error does not match context.DeadlineExceeded: %v", err)
+	}
+}
+
+func TestSendTasksTypedError(t *testing.T) {
+	conn := &Conn{
+		project:      "test",
+		queuesClient: failingQueuesClient{err: &codeError{code: 503}},
+	}
+
+	err := Queue(conn, "default").SendTasks(context.Background(), []*pb.SendTask{{Payload: []byte("foo")}})
+	var codeErr *codeError
+	if !errors.As(err, &codeErr) {
+		t.Fatalf("error does not match *codeError: %v", err)
+	}
+	if codeErr.code != 503 {
+		t.Errorf("unexpected code: %d", codeErr.code)
+	}
+}
+
+func TestTaskFanOutErrors(t *testing.T) {
+	r := NewRegistry()
+	f := r.Func("fanout", func(ctx context.Context) error { return nil })
+
+	failing := &Conn{
+		project:      "test",
+		queuesClient: failingQueuesClient{err: context.DeadlineExceeded},
+	}
+	m := NewInMemoryConn("test")
+	queues := []QueueSpec{m.Queue("default"), Queue(failing, "default")}
+
+	err := f.FanOut(context.Background(), queues)
+	var fanOutErr *FanOutError
+	if !errors.As(err, &fanOutErr) {
+		t.Fatalf("error does not match *FanOutError: %v", err)
+	}
+	if len(fanOutErr.Errors) != 1 || !errors.Is(fanOutErr.Errors[0], context.DeadlineExceeded) {
+		t.Errorf("unexpected errors: %v", fanOutErr.Errors)
+	}
+}
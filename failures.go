@@ -0,0 +1,31 @@
+package delay
+
+import (
+	"context"
+)
+
+// OnTimeout registers a callback called when the function of a task fails after
+// running out of time. It receives the arguments decoded from the task and runs
+// before the error handlers, with a context not cancelled by the timeout.
+func (f *Function) OnTimeout(fn func(ctx context.Context, args []interface{})) {
+	f.timeoutHandlers = append(f.timeoutHandlers, fn)
+}
+
+// OnPanic registers a callback called when the function of a task panics. It
+// receives the recovered value and the arguments decoded from the task and runs
+// before the error handlers.
+func (f *Function) OnPanic(fn func(ctx context.Context, recovered interface{}, args []interface{})) {
+	f.panicHandlers = append(f.panicHandlers, fn)
+}
+
+func (f *Function) runTimeoutHandlers(ctx context.Context, args []interface{}) {
+	for _, fn := range f.timeoutHandlers {
+		fn(ctx, args)
+	}
+}
+
+func (f *Function) runPanicHandlers(ctx context.Context, recovered interface{}, args []interface{}) {
+	for _, fn := range f.panicHandlers {
+		fn(ctx, recovered, args)
+	}
+}
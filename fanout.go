@@ -0,0 +1,73 @@
+package delay
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/altipla-consulting/delay/queues"
+)
+
+// FanOutError is returned when a task cannot be sent to some of the queues of a
+// fan out. The task was sent to the rest of queues.
+type FanOutError struct {
+	// Queues that failed and the error of each one in the same order.
+	Queues []QueueSpec
+	Errors []error
+}
+
+func (err *FanOutError) Error() string {
+	msgs := make([]string, len(err.Errors))
+	for i, queueErr := range err.Errors {
+		msgs[i] = fmt.Sprintf("%s: %s", err.Queues[i].name, queueErr)
+	}
+
+	return fmt.Sprintf("delay: cannot send task to %d queues: %s", len(err.Errors), strings.Join(msgs, "; "))
+}
+
+// FanOut builds a task invocation once and sends it to all the queues at the same
+// time, for example to process it and to keep an audit log. The task is sent to
+// every queue even if some of them fail, returning a *FanOutError with the failures.
+func (f *Function) FanOut(ctx context.Context, queues []QueueSpec, args ...interface{}) error {
+	task, err := f.Task(args...)
+	if err != nil {
+		return err
+	}
+
+	return f.TaskFanOut(ctx, queues, task)
+}
+
+// TaskFanOut sends a task built previously with Task to all the queues at the same
+// time. The task is sent to every queue even if some of them fail, returning a
+// *FanOutError with the failures.
+func (f *Function) TaskFanOut(ctx context.Context, queues []QueueSpec, task *pb.SendTask) error {
+	errs := make([]error, len(queues))
+	var wg sync.WaitGroup
+	for i, queue := range queues {
+		// Each queue saves the trace of the context in the headers of its own copy.
+		queueTask := proto.Clone(task).(*pb.SendTask)
+
+		wg.Add(1)
+		go func(i int, queue QueueSpec) {
+			defer wg.Done()
+			errs[i] = queue.SendTasks(ctx, []*pb.SendTask{queueTask})
+		}(i, queue)
+	}
+	wg.Wait()
+
+	fanOutErr := new(FanOutError)
+	for i, err := range errs {
+		if err != nil {
+			fanOutErr.Queues = append(fanOutErr.Queues, queues[i])
+			fanOutErr.Errors = append(fanOutErr.Errors, err)
+		}
+	}
+	if len(fanOutErr.Errors) > 0 {
+		return fanOutErr
+	}
+
+	return nil
+}
@@ -1,18 +1,51 @@
 module github.com/altipla-consulting/delay
 
+go 1.18
+
 require (
+	cloud.google.com/go v0.34.0
 	github.com/altipla-consulting/datetime v1.0.0
 	github.com/altipla-consulting/errors v1.0.0
 	github.com/altipla-consulting/sentry v0.3.1
-	github.com/certifi/gocertifi v0.0.0-20180905225744-ee1a9a0726d2 // indirect
-	github.com/getsentry/raven-go v0.2.0 // indirect
+	github.com/aws/aws-sdk-go-v2 v1.0.0
+	github.com/aws/aws-sdk-go-v2/service/sqs v1.0.0
 	github.com/go-redis/redis v6.14.2+incompatible
 	github.com/golang/protobuf v1.2.0
-	github.com/pkg/errors v0.8.0 // indirect
+	github.com/klauspost/compress v1.15.9
+	github.com/nats-io/nats.go v1.16.0
+	github.com/prometheus/client_golang v0.9.2
+	github.com/robfig/cron/v3 v3.0.1
+	github.com/segmentio/kafka-go v0.2.2
 	github.com/sirupsen/logrus v1.2.0
-	golang.org/x/net v0.0.0-20181201002055-351d144fa1fc
+	github.com/vmihailenco/msgpack/v5 v5.3.5
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
+	golang.org/x/net v0.0.0-20210226172049-e18ecbb05110
 	golang.org/x/oauth2 v0.0.0-20181203162652-d668ce993890
 	golang.org/x/sync v0.0.0-20181108010431-42b317875d0f
+	golang.org/x/time v0.0.0-20181108054448-85acf8d2951c
+	google.golang.org/api v0.1.0
 	google.golang.org/genproto v0.0.0-20181202183823-bd91e49a0898
-	google.golang.org/grpc v1.16.0
+	google.golang.org/grpc v1.17.0
+)
+
+require (
+	github.com/aws/smithy-go v1.0.0 // indirect
+	github.com/beorn7/perks v0.0.0-20180321164747-3a771d992973 // indirect
+	github.com/certifi/gocertifi v0.0.0-20180905225744-ee1a9a0726d2 // indirect
+	github.com/getsentry/raven-go v0.2.0 // indirect
+	github.com/googleapis/gax-go v2.0.2+incompatible // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
+	github.com/nats-io/nkeys v0.3.0 // indirect
+	github.com/nats-io/nuid v1.0.1 // indirect
+	github.com/pkg/errors v0.8.0 // indirect
+	github.com/prometheus/client_model v0.0.0-20180712105110-5c3871d89910 // indirect
+	github.com/prometheus/common v0.0.0-20181126121408-4724e9255275 // indirect
+	github.com/prometheus/procfs v0.0.0-20181204211112-1dc9a6cbc91a // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	go.opencensus.io v0.18.0 // indirect
+	golang.org/x/crypto v0.0.0-20210314154223-e6e6c4f2bb5b // indirect
+	golang.org/x/sys v0.0.0-20201119102817-f84b799fce68 // indirect
+	golang.org/x/term v0.0.0-20201126162022-7de9c90e9dd1 // indirect
+	golang.org/x/text v0.3.3 // indirect
 )
@@ -0,0 +1,55 @@
+package delay
+
+import (
+	"sync"
+)
+
+// FuncGroup registers functions with keys namespaced by a common prefix instead
+// of the path of the source file.
+type FuncGroup struct {
+	prefix string
+	parent *FuncGroup
+
+	mu    sync.Mutex
+	funcs []*Function
+}
+
+// NewFuncGroup creates a new group of functions. Keys of the functions will be
+// prefix + "/" + key.
+func NewFuncGroup(prefix string) *FuncGroup {
+	return &FuncGroup{prefix: prefix}
+}
+
+// SubGroup creates a nested group whose keys are prefixed by both groups.
+func (g *FuncGroup) SubGroup(prefix string) *FuncGroup {
+	return &FuncGroup{
+		prefix: g.prefix + "/" + prefix,
+		parent: g,
+	}
+}
+
+// Func builds and registers a new task implementation inside the group.
+func (g *FuncGroup) Func(key string, i interface{}, opts ...FuncOption) *Function {
+	full := g.prefix + "/" + key
+	f := defaultRegistry.register(full, key, full, i, opts)
+	for group := g; group != nil; group = group.parent {
+		group.add(f)
+	}
+
+	return f
+}
+
+func (g *FuncGroup) add(f *Function) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.funcs = append(g.funcs, f)
+}
+
+// ListFunctions returns the functions registered in the group and its subgroups.
+func (g *FuncGroup) ListFunctions() []*Function {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return append([]*Function(nil), g.funcs...)
+}
@@ -0,0 +1,87 @@
+package delay
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Seconds of finished tasks used to compute the error rate of the health checks.
+const errorRateWindow = 60
+
+// rateBucket counts the tasks finished during a second.
+type rateBucket struct {
+	second   int64
+	finished uint64
+	failed   uint64
+}
+
+// errorRate returns the fraction of the tasks that failed in the last minute.
+func (stats *listenerStats) errorRate(now time.Time) float64 {
+	var finished, failed uint64
+	for _, bucket := range stats.recent {
+		if now.Unix()-bucket.second < errorRateWindow {
+			finished += bucket.finished
+			failed += bucket.failed
+		}
+	}
+	if finished == 0 {
+		return 0
+	}
+
+	return float64(failed) / float64(finished)
+}
+
+type healthStatus struct {
+	Running        bool    `json:"running"`
+	ActiveTasks    int64   `json:"active_tasks"`
+	TotalProcessed uint64  `json:"total_processed"`
+	ErrorRate1m    float64 `json:"error_rate_1m"`
+}
+
+type healthFailure struct {
+	Running bool   `json:"running"`
+	Reason  string `json:"reason"`
+}
+
+// ServeHTTP implements http.Handler to use the listener in the liveness and
+// readiness probes of Kubernetes. It replies with 200 and the stats of the listener
+// when it is receiving tasks, or with 503 if it is not handling any queue or it
+// was stopped. It can be served in a different port than the application.
+func (lis *Listener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	lis.stats.mu.Lock()
+	var reply interface{}
+	status := http.StatusOK
+	switch {
+	case !lis.stats.handled:
+		status = http.StatusServiceUnavailable
+		reply = healthFailure{Reason: "listener not handling any queue"}
+	case lis.stats.handling == 0 || lis.isStopping():
+		status = http.StatusServiceUnavailable
+		reply = healthFailure{Reason: "listener stopped"}
+	default:
+		reply = healthStatus{
+			Running:        true,
+			ActiveTasks:    lis.ActiveTaskCount(),
+			TotalProcessed: lis.stats.succeeded + lis.stats.failed,
+			ErrorRate1m:    lis.stats.errorRate(time.Now()),
+		}
+	}
+	lis.stats.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if err := json.NewEncoder(w).Encode(reply); err != nil {
+		lis.logger.WithField("error", err.Error()).Error("Cannot write health check reply")
+	}
+}
@@ -0,0 +1,261 @@
+package delay
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/altipla-consulting/datetime"
+	"github.com/golang/protobuf/proto"
+	"github.com/segmentio/kafka-go"
+	log "github.com/sirupsen/logrus"
+
+	pb "github.com/altipla-consulting/delay/queues"
+)
+
+// Maximum ETA of the tasks sent to Kafka. Listeners wait for the ETA blocking the
+// partition, so longer waits are rejected.
+const kafkaMaxETA = time.Minute
+
+type kafkaBackend struct {
+	brokers []string
+	groupID string
+	dialer  *kafka.Dialer
+
+	mu      sync.Mutex
+	writers map[string]*kafka.Writer
+}
+
+// KafkaOption configures a Kafka connection when creating it.
+type KafkaOption func(b *kafkaBackend)
+
+// WithKafkaGroupID changes the consumer group of the listeners. All the listeners
+// of the same group share the tasks of the topics. By default "delay" is used.
+func WithKafkaGroupID(groupID string) KafkaOption {
+	return func(b *kafkaBackend) {
+		b.groupID = groupID
+	}
+}
+
+// WithKafkaDialer changes the dialer used to connect to the brokers, for example
+// to configure TLS or SASL authentication.
+func WithKafkaDialer(dialer *kafka.Dialer) KafkaOption {
+	return func(b *kafkaBackend) {
+		b.dialer = dialer
+	}
+}
+
+// NewConnKafka creates a connection that stores the tasks in Apache Kafka. Each
+// queue is a topic with the same name. Listeners commit the offset of a task only
+// after it finishes, and the tasks that fail without a retry policy, or with a non
+// retryable error and no dead-letter queue in the listener, are sent to the topic
+// "<name>-dlq".
+//
+// Tasks of each partition run one after the other to commit the offsets in order.
+// Tasks with an ETA in the future wait before running, blocking the partition
+// until then. Sending tasks with an ETA more than 1 minute in the future fails;
+// the ones received anyway are sent to the dead-letter topic without running them.
+// Messages that cannot be decoded are sent to the dead-letter topic too.
+func NewConnKafka(brokers []string, opts ...KafkaOption) (*Conn, error) {
+	if len(brokers) == 0 {
+		return nil, fmt.Errorf("delay: kafka brokers required")
+	}
+
+	b := &kafkaBackend{
+		brokers: brokers,
+		groupID: "delay",
+		writers: make(map[string]*kafka.Writer),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return &Conn{backend: b}, nil
+}
+
+func (b *kafkaBackend) writer(topic string) *kafka.Writer {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if w, ok := b.writers[topic]; ok {
+		return w
+	}
+	w := kafka.NewWriter(kafka.WriterConfig{
+		Brokers: b.brokers,
+		Topic:   topic,
+		Dialer:  b.dialer,
+	})
+	b.writers[topic] = w
+
+	return w
+}
+
+func (b *kafkaBackend) sendTasks(ctx context.Context, queueName string, tasks []*pb.SendTask) error {
+	msgs := make([]kafka.Message, 0, len(tasks))
+	for _, task := range tasks {
+		if time.Until(datetime.ParseTimestamp(task.MinEta)) > kafkaMaxETA {
+			return fmt.Errorf("delay: kafka queues do not support ETAs more than %v in the future", kafkaMaxETA)
+		}
+		encoded, err := proto.Marshal(task)
+		if err != nil {
+			return fmt.Errorf("delay: cannot encode task: %w", err)
+		}
+		msgs = append(msgs, kafka.Message{Value: encoded})
+	}
+
+	if err := b.writer(queueName).WriteMessages(ctx, msgs...); err != nil {
+		return fmt.Errorf("delay: cannot send tasks to kafka: %w", err)
+	}
+
+	return nil
+}
+
+func (b *kafkaBackend) listen(ctx context.Context, queueName string, handler func(task *pb.Task) error) error {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: b.brokers,
+		GroupID: b.groupID,
+		Topic:   queueName,
+		Dialer:  b.dialer,
+	})
+	defer reader.Close()
+
+	for {
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("delay: cannot receive tasks from kafka: %w", err)
+		}
+
+		sendTask := new(pb.SendTask)
+		if err := proto.Unmarshal(msg.Value, sendTask); err != nil {
+			// Skip the message to avoid blocking the partition forever, keeping the
+			// raw message as the payload of the dead-letter task.
+			err = fmt.Errorf("delay: cannot decode incoming task: %w", err)
+			if err := b.deadLetter(queueName, &pb.SendTask{
+				Payload: msg.Value,
+				Headers: map[string]string{
+					HeaderDeadLetterError: err.Error(),
+					HeaderDeadLetterQueue: queueName,
+				},
+			}); err != nil {
+				return err
+			}
+			log.WithFields(log.Fields{
+				"queue":  queueName,
+				"offset": msg.Offset,
+				"error":  err.Error(),
+			}).Error("Cannot decode incoming task, sent to the dead-letter topic")
+
+			if err := reader.CommitMessages(context.Background(), msg); err != nil {
+				return fmt.Errorf("delay: cannot commit task: %w", err)
+			}
+			continue
+		}
+		task := &pb.Task{
+			Code:             fmt.Sprintf("%s-%d-%d", msg.Topic, msg.Partition, msg.Offset),
+			Payload:          sendTask.Payload,
+			Retry:            sendTask.Retry,
+			MinEta:           sendTask.MinEta,
+			Headers:          sendTask.Headers,
+			Priority:         sendTask.Priority,
+			DeduplicationKey: sendTask.DeduplicationKey,
+			Ttl:              sendTask.Ttl,
+			Tags:             sendTask.Tags,
+		}
+		if !msg.Time.IsZero() {
+			task.Created = datetime.SerializeTimestamp(msg.Time)
+		}
+
+		eta := time.Until(datetime.ParseTimestamp(task.MinEta))
+		if eta > kafkaMaxETA {
+			err := fmt.Errorf("delay: task ETA more than %v in the future", kafkaMaxETA)
+			if err := b.deadLetter(queueName, &pb.SendTask{
+				Payload: task.Payload,
+				Headers: deadLetterHeaders(task, err),
+			}); err != nil {
+				return err
+			}
+			log.WithFields(log.Fields{
+				"queue": queueName,
+				"task":  task.Code,
+				"eta":   datetime.ParseTimestamp(task.MinEta),
+			}).Error("Task ETA too far in the future, sent to the dead-letter topic")
+
+			if err := reader.CommitMessages(context.Background(), msg); err != nil {
+				return fmt.Errorf("delay: cannot commit task: %w", err)
+			}
+			continue
+		}
+		if eta > 0 {
+			timer := time.NewTimer(eta)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				// The offset is not committed, other listener will receive the task.
+				timer.Stop()
+				return nil
+			}
+		}
+
+		// Commit and send the task to the dead-letter topic even if the listener is stopping.
+		if err := handler(task); err != nil {
+			if err := b.deadLetter(queueName, &pb.SendTask{
+				Payload: task.Payload,
+				Headers: deadLetterHeaders(task, err),
+			}); err != nil {
+				return err
+			}
+
+			log.WithFields(log.Fields{
+				"queue": queueName,
+				"task":  task.Code,
+				"retry": task.Retry,
+				"dlq":   queueName + "-dlq",
+			}).Error("Task failed, sent to the dead-letter topic")
+		}
+		if err := reader.CommitMessages(context.Background(), msg); err != nil {
+			return fmt.Errorf("delay: cannot commit task: %w", err)
+		}
+	}
+}
+
+// deadLetter sends the task to the dead-letter topic of the queue, even if the
+// listener is stopping.
+func (b *kafkaBackend) deadLetter(queueName string, task *pb.SendTask) error {
+	return b.sendTasks(context.Background(), queueName+"-dlq", []*pb.SendTask{task})
+}
+
+func (b *kafkaBackend) ping(ctx context.Context) error {
+	dialer := b.dialer
+	if dialer == nil {
+		dialer = kafka.DefaultDialer
+	}
+
+	var lastErr error
+	for _, broker := range b.brokers {
+		conn, err := dialer.DialContext(ctx, "tcp", broker)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return conn.Close()
+	}
+
+	return fmt.Errorf("delay: cannot ping kafka: %w", lastErr)
+}
+
+func (b *kafkaBackend) close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, w := range b.writers {
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("delay: cannot close kafka writer: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,1248 @@
+package delay
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/signal"
+	"reflect"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/altipla-consulting/datetime"
+	"github.com/go-redis/redis"
+	"github.com/golang/protobuf/proto"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/errgroup"
+
+	pb "github.com/altipla-consulting/delay/queues"
+)
+
+// DefaultTaskTimeout is the maximum time a task can run if neither the listener
+// nor the function configure a different one.
+const DefaultTaskTimeout = 30 * time.Second
+
+// Listener is a background goroutine that handles messages from the queues
+// and run them in other controlled goroutines.
+type Listener struct {
+	registry      *Registry
+	errorReporter ErrorReporter
+	errorHandler  ErrorHandler
+	taskTimeout   time.Duration
+	deadLetter    *QueueSpec
+	metrics       MetricsReporter
+	middlewares   []HandlerMiddleware
+	logger        *log.Entry
+	slowTask      time.Duration
+	audit         *auditLog
+
+	tracerProvider trace.TracerProvider
+	callbacks      taskCallbacks
+	stats          listenerStats
+
+	// Pool of workers that run the tasks of all the queues. If workerCount is zero
+	// every task runs in its own goroutine.
+	workerCount int
+	minWorkers  int
+	jobs        chan func()
+	adaptive    *adaptiveConcurrency
+	budget      *costBudget
+
+	// Number of tasks running right now. idle is closed when it drops to zero.
+	activeMu        sync.Mutex
+	active          int64
+	idle            chan struct{}
+	reservedWorkers int
+
+	// ctx is the parent of all the tasks contexts. It is cancelled when stopping
+	// the listener takes too much time.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// resumed is closed when resuming a paused listener. It is nil if the listener
+	// is not paused.
+	pauseMu sync.Mutex
+	resumed chan struct{}
+
+	stopOnce sync.Once
+	stopping chan struct{}
+	done     chan struct{}
+	queues   sync.WaitGroup
+
+	handledMu sync.Mutex
+	handled   []QueueSpec
+}
+
+// SetLogger replaces the global logger of logrus with the entry in all the logs of
+// the listener, so they include its fields. It should be called before handling
+// any queue.
+func (lis *Listener) SetLogger(entry *log.Entry) {
+	lis.logger = entry
+}
+
+// ListenerOption configures a listener when creating it.
+type ListenerOption func(lis *Listener)
+
+// WithRegistry runs the tasks of the functions of the registry instead of the ones
+// registered with Func.
+func WithRegistry(r *Registry) ListenerOption {
+	return func(lis *Listener) {
+		lis.registry = r
+	}
+}
+
+// WithTaskTimeout changes the default maximum time a task can run before its context
+// is cancelled. Functions registered with WithTimeout will override this value.
+func WithTaskTimeout(d time.Duration) ListenerOption {
+	return func(lis *Listener) {
+		lis.taskTimeout = d
+	}
+}
+
+// WithSlowTaskThreshold logs a warning with the duration of the tasks that run for
+// longer than d. The rest of durations are logged with the debug level.
+func WithSlowTaskThreshold(d time.Duration) ListenerOption {
+	return func(lis *Listener) {
+		lis.slowTask = d
+	}
+}
+
+// WithDeadLetterQueue sends the tasks that exhaust all their retry attempts to
+// the queue. The original payload is preserved and the failure details are added
+// to the headers of the task, so the queue can be drained or replayed later.
+func WithDeadLetterQueue(dlq QueueSpec) ListenerOption {
+	return func(lis *Listener) {
+		lis.deadLetter = &dlq
+	}
+}
+
+// WithWorkerCount limits the number of tasks that run at the same time to a fixed
+// pool of n workers shared by all the queues of the listener. When all of them
+// are busy the listener stops receiving tasks until one of the workers is free.
+func WithWorkerCount(n int) ListenerOption {
+	return func(lis *Listener) {
+		lis.workerCount = n
+	}
+}
+
+// WithPerQueueMinWorkers reserves n workers for each queue apart from the pool
+// configured with WithWorkerCount. Reserved workers only run the tasks of their
+// queue, so the queue always makes progress even if the shared pool is busy with
+// the tasks of other queues. It has no effect without WithWorkerCount.
+func WithPerQueueMinWorkers(n int) ListenerOption {
+	return func(lis *Listener) {
+		lis.minWorkers = n
+	}
+}
+
+// ErrorReporter receives the errors of the tasks that fail to send them to an
+// external error tracking service.
+type ErrorReporter interface {
+	Report(ctx context.Context, err error)
+}
+
+// WithErrorReporter sends the errors of the tasks to the reporter. Errors can be
+// sent to Sentry with WithErrorReporter(sentryreporter.New(dsn)), importing the
+// package github.com/altipla-consulting/delay/sentryreporter.
+func WithErrorReporter(reporter ErrorReporter) ListenerOption {
+	return func(lis *Listener) {
+		lis.errorReporter = reporter
+	}
+}
+
+// NewListener prepares a new background goroutine to handle messages. Without
+// options the listener does not report errors anywhere apart from the logs and
+// each task runs in its own goroutine with DefaultTaskTimeout.
+func NewListener(opts ...ListenerOption) *Listener {
+	lis := &Listener{
+		registry:    defaultRegistry,
+		taskTimeout: DefaultTaskTimeout,
+		metrics:     NoopMetricsReporter{},
+		logger:      log.NewEntry(log.StandardLogger()),
+		stopping:    make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	lis.stats.started = time.Now()
+	lis.ctx, lis.cancel = context.WithCancel(context.Background())
+	lis.middlewares = []HandlerMiddleware{lis.reportErrors}
+	for _, opt := range opts {
+		opt(lis)
+	}
+	if lis.workerCount > 0 {
+		lis.jobs = make(chan func(), lis.workerCount)
+		lis.budget = newCostBudget(float64(lis.workerCount))
+		for i := 0; i < lis.workerCount; i++ {
+			go lis.runWorker(nil)
+		}
+	}
+	if lis.adaptive != nil {
+		go lis.adaptConcurrency()
+	}
+
+	return lis
+}
+
+// HandleOption configures how the listener consumes the tasks of a queue.
+type HandleOption func(opts *handleOptions)
+
+type handleOptions struct {
+	priorityOrdering bool
+	prefetchCount    int
+	tagFilter        func(tags []string) bool
+
+	// Workers reserved for the queue apart from the shared pool of the listener.
+	reserved chan func()
+}
+
+// WithPriorityOrdering prefers the tasks with higher priority when multiple of
+// them are available at the same time. Tasks with the same priority are still
+// received in order of arrival.
+func WithPriorityOrdering() HandleOption {
+	return func(opts *handleOptions) {
+		opts.priorityOrdering = true
+	}
+}
+
+// WithPrefetchCount limits the number of tasks received from the queues server that
+// are running or waiting for a worker at the same time. The listener stops reading
+// from the connection when the limit is reached, so a burst of tasks does not
+// start an unbounded number of goroutines.
+func WithPrefetchCount(n int) HandleOption {
+	return func(opts *handleOptions) {
+		opts.prefetchCount = n
+	}
+}
+
+// Handle opens a listen connection to the queue and starts receiving tasks from it
+// in the background. It returns ErrConnClosed if the connection of the queue was
+// already closed, or an error if the connection does not support the options.
+func (lis *Listener) Handle(queue QueueSpec, opts ...HandleOption) error {
+	if queue.conn.isClosed() {
+		return ErrConnClosed
+	}
+
+	var options handleOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.tagFilter != nil {
+		// These backends count each delivery of a rejected task as a failure and
+		// would send it to the dead-letter queue without running it.
+		if queue.conn.redisStream != nil {
+			return fmt.Errorf("delay: tag filters not supported by redis stream queues")
+		}
+		switch queue.conn.backend.(type) {
+		case *kafkaBackend:
+			return fmt.Errorf("delay: tag filters not supported by kafka queues")
+		case *sqsBackend:
+			return fmt.Errorf("delay: tag filters not supported by sqs queues")
+		case *natsBackend:
+			return fmt.Errorf("delay: tag filters not supported by nats queues")
+		}
+		queue = queue.withTagFilter(options.tagFilter)
+	}
+
+	if lis.jobs != nil && lis.minWorkers > 0 {
+		options.reserved = make(chan func())
+		lis.reserveWorkers(options.reserved, lis.minWorkers)
+	}
+
+	lis.stats.queueHandled()
+	lis.handledMu.Lock()
+	lis.handled = append(lis.handled, queue)
+	lis.handledMu.Unlock()
+	lis.queues.Add(1)
+	go func() {
+		defer lis.queues.Done()
+		defer lis.stats.queueExited()
+		if options.reserved != nil {
+			defer lis.releaseWorkers(options.reserved, lis.minWorkers)
+		}
+
+		for {
+			if err := lis.listenQueue(queue, options); err != nil {
+				if queue.conn.isClosed() {
+					return
+				}
+				lis.logger.WithFields(log.Fields{
+					"error":   err.Error(),
+					"project": queue.conn.project,
+					"queue":   queue.name,
+				}).Error("Error listening to queue, retrying in 15 seconds")
+			}
+
+			select {
+			case <-lis.stopping:
+				return
+			case <-time.After(15 * time.Second):
+			}
+		}
+	}()
+
+	return nil
+}
+
+// HandleAll starts receiving tasks from all the queues in the background. The
+// queues share the pool of workers of the listener configured with WithWorkerCount,
+// so the free workers run the tasks of whichever queue has them ready. Use
+// WithPerQueueMinWorkers to avoid a busy queue starving the others.
+func (lis *Listener) HandleAll(queues ...QueueSpec) error {
+	for _, queue := range queues {
+		if err := lis.Handle(queue); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// HandleMultiple is an alias of HandleAll.
+func (lis *Listener) HandleMultiple(queues ...QueueSpec) error {
+	return lis.HandleAll(queues...)
+}
+
+// HandleAllByName is like HandleAll with the queues of the connection with the names.
+func (lis *Listener) HandleAllByName(conn *Conn, names ...string) error {
+	queues := make([]QueueSpec, len(names))
+	for i, name := range names {
+		queues[i] = Queue(conn, name)
+	}
+
+	return lis.HandleAll(queues...)
+}
+
+func (lis *Listener) reserveWorkers(jobs chan func(), n int) {
+	lis.activeMu.Lock()
+	lis.reservedWorkers += n
+	lis.activeMu.Unlock()
+	lis.resizeBudget()
+
+	for i := 0; i < n; i++ {
+		go func() {
+			for job := range jobs {
+				job()
+			}
+		}()
+	}
+}
+
+func (lis *Listener) releaseWorkers(jobs chan func(), n int) {
+	close(jobs)
+
+	lis.activeMu.Lock()
+	lis.reservedWorkers -= n
+	lis.activeMu.Unlock()
+	lis.resizeBudget()
+}
+
+// Stop stops receiving new tasks from the queues and waits until all the tasks
+// that are running finish. If the context expires before that the running tasks
+// are cancelled and the error of the context is returned.
+func (lis *Listener) Stop(ctx context.Context) error {
+	lis.stopOnce.Do(func() {
+		close(lis.stopping)
+		go func() {
+			lis.queues.Wait()
+			lis.cancel()
+			if lis.jobs != nil {
+				close(lis.jobs)
+			}
+			if err := lis.audit.close(); err != nil {
+				lis.logger.WithField("error", err.Error()).Error("Cannot close audit log")
+			}
+			close(lis.done)
+		}()
+	})
+
+	select {
+	case <-lis.done:
+		return nil
+	case <-ctx.Done():
+		lis.cancel()
+		return ctx.Err()
+	}
+}
+
+// DrainOnSIGTERM stops the listener when the process receives SIGTERM, waiting up to
+// the grace period for the running tasks to finish. The returned channel is closed
+// when the listener was stopped, either by the signal or by calling Stop directly.
+func (lis *Listener) DrainOnSIGTERM(gracePeriod time.Duration) <-chan struct{} {
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+		defer stop()
+
+		select {
+		case <-ctx.Done():
+		case <-lis.done:
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+		defer cancel()
+		if err := lis.Stop(ctx); err != nil {
+			lis.logger.WithField("error", err.Error()).Error("Running tasks cancelled after the grace period")
+		}
+	}()
+
+	return drained
+}
+
+func (lis *Listener) isStopping() bool {
+	select {
+	case <-lis.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// ActiveWorkers returns the number of tasks that are running right now.
+func (lis *Listener) ActiveWorkers() int {
+	return int(lis.ActiveTaskCount())
+}
+
+// ActiveTaskCount returns the number of tasks that are running right now.
+func (lis *Listener) ActiveTaskCount() int64 {
+	lis.activeMu.Lock()
+	defer lis.activeMu.Unlock()
+
+	return lis.active
+}
+
+// WaitForIdle blocks until there are no tasks running. If the context expires
+// before that its error is returned. New tasks may be received right after
+// returning unless the listener was stopped.
+func (lis *Listener) WaitForIdle(ctx context.Context) error {
+	lis.activeMu.Lock()
+	if lis.active == 0 {
+		lis.activeMu.Unlock()
+		return nil
+	}
+	idle := lis.idle
+	lis.activeMu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (lis *Listener) taskStarted() {
+	lis.activeMu.Lock()
+	defer lis.activeMu.Unlock()
+
+	if lis.active == 0 {
+		lis.idle = make(chan struct{})
+	}
+	lis.active++
+}
+
+func (lis *Listener) taskFinished() {
+	lis.activeMu.Lock()
+	defer lis.activeMu.Unlock()
+
+	lis.active--
+	if lis.active == 0 {
+		close(lis.idle)
+	}
+}
+
+// IdleWorkers returns the number of workers of the pool that are waiting for
+// new tasks. It is always zero if the listener was not created with WithWorkerCount.
+func (lis *Listener) IdleWorkers() int {
+	lis.activeMu.Lock()
+	defer lis.activeMu.Unlock()
+
+	if lis.workerCount == 0 {
+		return 0
+	}
+
+	return lis.workerCount + lis.reservedWorkers - int(lis.active)
+}
+
+// Done returns a channel that is closed when the listener has completely stopped
+// after calling Stop.
+func (lis *Listener) Done() <-chan struct{} {
+	return lis.done
+}
+
+func (lis *Listener) listenQueue(queue QueueSpec, options handleOptions) error {
+	if queue.conn.isClosed() {
+		return ErrConnClosed
+	}
+	if queue.conn.memory != nil {
+		return fmt.Errorf("delay: in-memory queues cannot be listened, use ProcessAll instead")
+	}
+	if queue.conn.redisClient != nil {
+		return lis.listenRedis(queue, options)
+	}
+	if queue.conn.redisStream != nil {
+		return lis.listenRedisStream(queue, options)
+	}
+	if queue.conn.backend != nil {
+		return lis.listenBackend(queue, options)
+	}
+
+	return lis.listenStream(queue, options)
+}
+
+func (lis *Listener) listenRedis(queue QueueSpec, options handleOptions) error {
+	pubsub := queue.conn.redisClient.Subscribe(queue.redisKey())
+	defer pubsub.Close()
+
+	var i int64
+	msgs := pubsub.Channel()
+	for {
+		var msg *redis.Message
+		select {
+		case <-lis.stopping:
+			return nil
+		case msg = <-msgs:
+		}
+		if msg == nil {
+			return nil
+		}
+		if !lis.waitResumed(lis.ctx) {
+			return nil
+		}
+
+		var sendTasks []*pb.SendTask
+		buf := proto.NewBuffer([]byte(msg.Payload))
+		for {
+			sendTask := new(pb.SendTask)
+			if err := buf.DecodeMessage(sendTask); err != nil {
+				if err == io.EOF {
+					break
+				}
+
+				return fmt.Errorf("delay: cannot decode incoming task: %w", err)
+			}
+			sendTasks = append(sendTasks, sendTask)
+		}
+		if options.priorityOrdering {
+			sort.SliceStable(sendTasks, func(i, j int) bool {
+				return sendTasks[i].Priority > sendTasks[j].Priority
+			})
+		}
+
+		for _, sendTask := range sendTasks {
+			// There is no storage in the debug queue, tasks are kept in memory until their ETA.
+			if wait := time.Until(datetime.ParseTimestamp(sendTask.MinEta)); wait > 0 {
+				lis.delayRedisTask(queue, sendTask, wait)
+				continue
+			}
+
+			i++
+			task := &pb.Task{
+				Code:             fmt.Sprintf("sim-%d", i),
+				Payload:          sendTask.Payload,
+				Created:          datetime.SerializeTimestamp(time.Now()),
+				Retry:            sendTask.Retry,
+				Project:          queue.conn.project,
+				QueueName:        queue.name,
+				MinEta:           sendTask.MinEta,
+				Headers:          sendTask.Headers,
+				Priority:         sendTask.Priority,
+				DeduplicationKey: sendTask.DeduplicationKey,
+				Ttl:              sendTask.Ttl,
+				Tags:             sendTask.Tags,
+			}
+
+			lis.logger.WithFields(log.Fields{
+				"project": task.Project,
+				"queue":   task.QueueName,
+				"task":    task.Code,
+			}).Debug("Task received")
+
+			// Failures are reported by the middlewares and there is nothing to ack. We
+			// simulate the retries of the queues server instead.
+			// Every listener receives all the tasks of the debug queue, the filtered
+			// ones are run by others.
+			requeued, err := lis.handleTask(lis.ctx, queue, task)
+			if err != nil && err != errTaskFiltered && !requeued && !IsNonRetryable(err) {
+				if err := lis.retryRedisTask(queue, task, err); err != nil {
+					lis.logger.WithFields(log.Fields{
+						"error":   err.Error(),
+						"project": task.Project,
+						"queue":   task.QueueName,
+						"task":    task.Code,
+					}).Error("Cannot retry failed task")
+				}
+			}
+			lis.callbacks.taskCompleted(lis.ctx, task, err)
+		}
+	}
+}
+
+// Retries made by the debug queue before sending the task to the dead-letter list.
+// The first retry is made after debugRetryDelay, doubling it each time.
+const (
+	debugMaxRetries = 10
+	debugRetryDelay = time.Second
+)
+
+func (lis *Listener) delayRedisTask(queue QueueSpec, sendTask *pb.SendTask, wait time.Duration) {
+	time.AfterFunc(wait, func() {
+		if err := queue.SendTasks(context.Background(), []*pb.SendTask{sendTask}); err != nil {
+			lis.logger.WithFields(log.Fields{
+				"error":   err.Error(),
+				"project": queue.conn.project,
+				"queue":   queue.name,
+			}).Error("Cannot send delayed task to the debug queue")
+		}
+	})
+}
+
+// retryRedisTask publishes again a failed task in the debug queue or pushes it
+// to the list of the queue with the suffix ":dlq" when it exhausts all the retries.
+func (lis *Listener) retryRedisTask(queue QueueSpec, task *pb.Task, taskErr error) error {
+	if task.Retry >= debugMaxRetries {
+		dead, err := proto.Marshal(&pb.SendTask{
+			Payload: task.Payload,
+			Headers: deadLetterHeaders(task, taskErr),
+		})
+		if err != nil {
+			return fmt.Errorf("delay: cannot encode task: %w", err)
+		}
+		if err := queue.conn.redisClient.RPush(queue.redisKey()+":dlq", dead).Err(); err != nil {
+			return fmt.Errorf("delay: cannot send task to the debug dead-letter list: %w", err)
+		}
+
+		lis.logger.WithFields(log.Fields{
+			"project": task.Project,
+			"queue":   task.QueueName,
+			"task":    task.Code,
+			"retry":   task.Retry,
+			"dlq":     queue.redisKey() + ":dlq",
+		}).Error("Task exhausted all retry attempts, sent to the dead-letter list")
+
+		return nil
+	}
+
+	eta := time.Now().Add(debugRetryDelay << uint(task.Retry))
+	retry := &pb.SendTask{
+		Payload:  task.Payload,
+		MinEta:   datetime.SerializeTimestamp(eta),
+		Retry:    task.Retry + 1,
+		Headers:  task.Headers,
+		Priority: task.Priority,
+		Ttl:      retryTTL(task, eta),
+		Tags:     task.Tags,
+	}
+	lis.callbacks.taskRetried(lis.ctx, task, retry.Retry, eta)
+	if err := queue.SendTasks(lis.ctx, []*pb.SendTask{retry}); err != nil {
+		return fmt.Errorf("delay: cannot retry task: %w", err)
+	}
+
+	return nil
+}
+
+func (lis *Listener) listenStream(queue QueueSpec, options handleOptions) error {
+	streamCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	group, ctx := errgroup.WithContext(streamCtx)
+
+	stream, err := queue.conn.queuesClient.Listen(ctx)
+	if err != nil {
+		return fmt.Errorf("delay: cannot listen to the queue: %w", err)
+	}
+
+	initial := &pb.ListenRequest{
+		Request: &pb.ListenRequest_Initial{
+			Initial: &pb.ListenInitial{
+				Project:          queue.conn.project,
+				QueueName:        queue.name,
+				PriorityOrdering: options.priorityOrdering,
+			},
+		},
+	}
+	if err := stream.Send(initial); err != nil {
+		return fmt.Errorf("delay: cannot send initial connection info: %w", err)
+	}
+
+	// Slots of the tasks that are prefetched. They are acquired before receiving
+	// each task and released when it finishes.
+	var prefetch chan struct{}
+	if options.prefetchCount > 0 {
+		prefetch = make(chan struct{}, options.prefetchCount)
+	}
+	release := func() {
+		if prefetch != nil {
+			<-prefetch
+		}
+	}
+
+	tasks := make(chan *pb.Task)
+	recvDone := make(chan struct{})
+	group.Go(func() error {
+		defer close(recvDone)
+
+		for {
+			if prefetch != nil {
+				select {
+				case prefetch <- struct{}{}:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+
+			reply, err := stream.Recv()
+			if err != nil {
+				// The stream is closed on purpose when stopping the listener or
+				// when other goroutine fails and has already returned the error.
+				if lis.isStopping() || ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("delay: cannot receive tasks: %w", err)
+			}
+
+			select {
+			case tasks <- reply.Task:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	})
+
+	// Acks are sent from multiple goroutines and the stream does not support
+	// concurrent calls to Send.
+	var sendMu sync.Mutex
+	var running sync.WaitGroup
+
+	// Tasks running in the worker pool cannot return their errors to the group
+	// and they are collected here instead.
+	var poolErr error
+	var poolErrOnce sync.Once
+	submit := func(job func() error) {
+		if lis.jobs == nil {
+			group.Go(job)
+			return
+		}
+
+		run := func() {
+			if err := job(); err != nil {
+				poolErrOnce.Do(func() {
+					poolErr = err
+					cancel()
+				})
+			}
+		}
+		select {
+		case lis.jobs <- run:
+		case options.reserved <- run:
+		case <-ctx.Done():
+			running.Done()
+			release()
+		}
+	}
+	group.Go(func() error {
+		defer cancel()
+
+	loop:
+		for {
+			var task *pb.Task
+			select {
+			case <-lis.stopping:
+				break loop
+			case <-ctx.Done():
+				break loop
+			case task = <-tasks:
+			}
+			if !lis.waitResumed(ctx) {
+				release()
+				break loop
+			}
+
+			running.Add(1)
+			submit(func() error {
+				defer running.Done()
+				defer release()
+
+				lis.logger.WithFields(log.Fields{
+					"project": task.Project,
+					"queue":   task.QueueName,
+					"task":    task.Code,
+				}).Debug("Task received")
+
+				requeued, err := lis.handleTask(lis.ctx, queue, task)
+				if err == errTaskFiltered {
+					// Acking the task sent again does not use the retries of the server.
+					if releaseErr := lis.releaseTask(lis.ctx, queue, task); releaseErr != nil {
+						lis.logger.WithFields(log.Fields{
+							"error":   releaseErr.Error(),
+							"project": task.Project,
+							"queue":   task.QueueName,
+							"task":    task.Code,
+						}).Error("Cannot release filtered task")
+					} else {
+						requeued = true
+					}
+				}
+				req := &pb.ListenRequest{
+					Request: &pb.ListenRequest_Ack{
+						Ack: &pb.Ack{
+							Code:    task.Code,
+							Success: err == nil || requeued || IsNonRetryable(err),
+						},
+					},
+				}
+				sendMu.Lock()
+				sendErr := stream.Send(req)
+				sendMu.Unlock()
+				if sendErr != nil {
+					return fmt.Errorf("delay: cannot ack task: %w", sendErr)
+				}
+				lis.callbacks.taskCompleted(lis.ctx, task, err)
+
+				return nil
+			})
+		}
+
+		// Wait for the running tasks to send their acks before closing the stream.
+		running.Wait()
+		if poolErr != nil {
+			return poolErr
+		}
+		sendMu.Lock()
+		err := stream.CloseSend()
+		sendMu.Unlock()
+		if err != nil {
+			return fmt.Errorf("delay: cannot close the stream: %w", err)
+		}
+
+		// Give the server some time to receive the last acks and close its side.
+		select {
+		case <-recvDone:
+		case <-time.After(5 * time.Second):
+		}
+
+		return nil
+	})
+
+	if err := group.Wait(); err != nil {
+		return fmt.Errorf("delay: error closing the background queue goroutines: %w", err)
+	}
+
+	return nil
+}
+
+// handleTask runs the task through the middlewares and sends it again to the queue
+// if it fails and the function has a retry policy. It returns true if the failed
+// task should not be retried by the server, because it was enqueued again, either
+// as a retry or in the dead-letter queue. Tasks that are not retryable return an
+// error marked with NonRetryable to drop them if nothing enqueued them.
+func (lis *Listener) handleTask(ctx context.Context, queue QueueSpec, task *pb.Task) (bool, error) {
+	if !queue.acceptsTask(task) {
+		return false, errTaskFiltered
+	}
+
+	ctx = withTaskCode(ctx, task.Code)
+	ctx = withQueueName(ctx, task.QueueName)
+	ctx = withRetryCount(ctx, task.Retry)
+	ctx = withHeaders(ctx, task.Headers)
+	if id := task.Headers[HeaderCorrelationID]; id != "" {
+		ctx = WithCorrelationID(ctx, id)
+	}
+
+	lis.callbacks.taskReceived(ctx, task)
+	lis.stats.taskReceived()
+	lis.audit.write(AuditReceived, task, nil, 0, nil)
+
+	if expiry, ok := taskExpiry(task); ok && expiry.Before(time.Now()) {
+		lis.logger.WithFields(log.Fields{
+			"project": task.Project,
+			"queue":   task.QueueName,
+			"task":    task.Code,
+			"expiry":  expiry.Format(time.RFC3339),
+		}).Warning("Task expired")
+
+		return false, nil
+	}
+
+	lis.taskStarted()
+	defer lis.taskFinished()
+
+	ctx, span := lis.startTaskSpan(ctx, task)
+	defer span.End()
+
+	var f *Function
+	var duration time.Duration
+	err := func() (err error) {
+		defer recoverPanic(&err)
+
+		// The task is decoded before running the middlewares, because the ones of
+		// the function run before the ones of the listener. The errors found before
+		// that still go through the middlewares of the listener to report them.
+		fail := func(err error) error {
+			return lis.runMiddlewares(ctx, task, func(ctx context.Context) error {
+				return err
+			})
+		}
+
+		hookCtx, hookErr := lis.callbacks.runHooks(ctx, task)
+
+		var codec Codec
+		var inv invocation
+		payload, err := decryptPayload(lis.registry.decryptionKeys(), task.Payload)
+		if err == nil {
+			f, codec, inv, err = lis.decodeInvocation(payload)
+		}
+		// The function is still needed to retry the tasks rejected by the hooks.
+		if hookErr != nil {
+			return fail(hookErr)
+		}
+		if err != nil {
+			return fail(err)
+		}
+		ctx := hookCtx
+		lis.checkDeduplicationKey(task, inv.Key, payload)
+		if isShadowTask(task) {
+			lis.runShadowTask(ctx, f, codec, task, inv.Args)
+			return nil
+		}
+		if f.distLimiter != nil {
+			wait, err := f.distLimiter.wait(ctx, f.key)
+			if err != nil {
+				return fail(err)
+			}
+			if wait > 0 {
+				if err := lis.throttleTask(ctx, queue, task, wait); err != nil {
+					return fail(err)
+				}
+				return nil
+			}
+		}
+		// The copy is sent only once, the retries of the task would duplicate it.
+		if f.shadow != nil && task.Retry == 0 {
+			defer lis.sendShadowTask(f, task)
+		}
+		if lis.budget != nil {
+			slots, err := lis.budget.acquire(ctx, f.taskCost())
+			if err != nil {
+				return fail(err)
+			}
+			defer lis.budget.release(slots)
+		}
+
+		span.SetAttributes(
+			attribute.String("delay.function", f.Key()),
+			attribute.String("code.function", f.FuncName()),
+		)
+
+		logArgs := f.sanitizeArgs(inv.Args)
+		if logArgs != nil {
+			ctx = withLogArgs(ctx, logArgs)
+		}
+
+		lis.metrics.TaskReceived(queue.name, f.key)
+		lis.stats.functionStarted(f.key)
+		lis.callbacks.taskStarted(ctx, task)
+		lis.audit.write(AuditStarted, task, f, 0, nil)
+		start := time.Now()
+		var results []interface{}
+		err = lis.runFunctionMiddlewares(ctx, f, task, func(ctx context.Context) error {
+			var err error
+			results, err = lis.invoke(ctx, f, codec, inv.Args)
+			return err
+		})
+		duration = time.Since(start)
+		lis.logDuration(f, task, logArgs, duration, err == nil)
+		if err != nil {
+			lis.metrics.TaskFailed(queue.name, f.key, task.Retry, duration)
+			lis.stats.functionFinished(f.key, duration, err)
+			return err
+		}
+		lis.metrics.TaskSucceeded(queue.name, f.key, duration)
+		lis.stats.functionFinished(f.key, duration, nil)
+		lis.callbacks.taskSucceeded(ctx, task, duration)
+		lis.audit.write(AuditSucceeded, task, f, duration, nil)
+		lis.saveResult(ctx, f, task, results, nil)
+
+		return nil
+	}()
+	lis.stats.taskFinished(err)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	if err == nil {
+		return false, nil
+	}
+	lis.handleError(ctx, queue, f, task, err)
+	if f == nil {
+		lis.callbacks.taskFailed(ctx, task, err, true)
+		lis.audit.write(AuditFailed, task, nil, duration, err)
+		return false, err
+	}
+
+	requeued, retryErr := lis.retryTask(ctx, queue, f, task, err)
+	if retryErr != nil {
+		lis.logger.WithFields(log.Fields{
+			"error":   retryErr.Error(),
+			"project": task.Project,
+			"queue":   task.QueueName,
+			"task":    task.Code,
+			"headers": task.Headers,
+		}).Error("Cannot retry failed task")
+	}
+
+	// Tasks not enqueued again are retried by the server, the ones enqueued are
+	// retries unless they went to the dead-letter queue.
+	retrying := f.isRetryable(err)
+	if requeued && (f.retryPolicy == nil || task.Retry+1 >= f.retryPolicy.MaxAttempts) {
+		retrying = false
+	}
+	lis.callbacks.taskFailed(ctx, task, err, retrying)
+	lis.audit.write(AuditFailed, task, f, duration, err)
+	if !retrying {
+		lis.saveResult(ctx, f, task, nil, err)
+	}
+
+	// Errors rejected by the retry predicate of the function are marked too, so the
+	// callers know the task should not be retried even if it was not enqueued again.
+	if !f.isRetryable(err) && !IsNonRetryable(err) {
+		err = NonRetryable(err)
+	}
+
+	return requeued, err
+}
+
+// checkDeduplicationKey warns if the task was sent with a computed deduplication
+// key that does not match its content anymore. Custom keys cannot be checked.
+func (lis *Listener) checkDeduplicationKey(task *pb.Task, key string, payload []byte) {
+	if !strings.HasPrefix(task.DeduplicationKey, computedKeyPrefix) {
+		return
+	}
+
+	if task.DeduplicationKey != deduplicationKey(key, payload) {
+		lis.logger.WithFields(log.Fields{
+			"project": task.Project,
+			"queue":   task.QueueName,
+			"task":    task.Code,
+			"key":     task.DeduplicationKey,
+		}).Warning("Task payload does not match its deduplication key")
+	}
+}
+
+// logDuration logs the time the function of the task spent running. The arguments
+// are only logged if the function sanitizes them.
+func (lis *Listener) logDuration(f *Function, task *pb.Task, logArgs []interface{}, duration time.Duration, success bool) {
+	entry := lis.logger.WithFields(log.Fields{
+		"project":     task.Project,
+		"queue":       task.QueueName,
+		"task_code":   task.Code,
+		"function":    f.key,
+		"duration_ms": duration.Milliseconds(),
+		"success":     success,
+	})
+	if logArgs != nil {
+		entry = entry.WithField("args", logArgs)
+	}
+	if lis.slowTask > 0 && duration > lis.slowTask {
+		entry.Warning("Slow task")
+		return
+	}
+	entry.Debug("Task finished")
+}
+
+// recoverPanic should be deferred to replace the returned error with the panic
+// and the stack trace of the goroutine if there is one.
+func recoverPanic(err *error) {
+	if r := recover(); r != nil {
+		*err = panicError(r)
+	}
+}
+
+func panicError(r interface{}) error {
+	return fmt.Errorf("delay: panic: %v\n%s", r, debug.Stack())
+}
+
+// invoke runs the function with the arguments and returns the values it returned
+// without the error.
+func (lis *Listener) invoke(ctx context.Context, f *Function, codec Codec, args []interface{}) (results []interface{}, err error) {
+	// Function panics are recovered here so the middlewares can report them too.
+	parent := ctx
+	defer func() {
+		if r := recover(); r != nil {
+			err = panicError(r)
+			f.runPanicHandlers(parent, r, args)
+		}
+	}()
+
+	timeout := f.timeout
+	if timeout == 0 {
+		timeout = lis.taskTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if f.limiter != nil {
+		if err := f.limiter.Wait(ctx); err != nil {
+			return nil, fmt.Errorf("delay: rate limit exceeded: %w", err)
+		}
+	}
+
+	ft := f.fv.Type()
+	in := []reflect.Value{reflect.ValueOf(ctx)}
+	for _, arg := range args {
+		n := len(in) // we're constructing the nth argument
+		at := argType(ft, n)
+
+		var v reflect.Value
+		if arg != nil {
+			var err error
+			v, err = convertArg(codec, arg, at)
+			if err != nil {
+				return nil, fmt.Errorf("delay: cannot decode argument %d: %w", n, err)
+			}
+		} else {
+			// Task was passed a nil argument, so we must construct
+			// the zero value for the argument here.
+			v = reflect.Zero(at)
+		}
+		// Handlers can modify the arguments in place without affecting the decoded
+		// values shared with the rest of the listener.
+		in = append(in, deepCopy(v))
+	}
+	out := f.fv.Call(in)
+
+	if n := ft.NumOut(); n > 0 && ft.Out(n-1) == errorType {
+		if errv := out[n-1]; !errv.IsNil() {
+			if ctx.Err() == context.DeadlineExceeded && parent.Err() == nil {
+				f.runTimeoutHandlers(parent, args)
+			}
+			return nil, fmt.Errorf("delay: handler failed: %w", errv.Interface().(error))
+		}
+		out = out[:n-1]
+	}
+	for _, v := range out {
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+			// Gob cannot encode nil values inside an interface.
+			if v.IsNil() {
+				results = append(results, nil)
+				continue
+			}
+		}
+		results = append(results, v.Interface())
+	}
+
+	return results, nil
+}
+
+// retryTask sends again a failed task to the queue following the retry policy
+// of the function. It returns true if the task was enqueued again or should be
+// discarded because is not retryable.
+func (lis *Listener) retryTask(ctx context.Context, queue QueueSpec, f *Function, task *pb.Task, taskErr error) (bool, error) {
+	if !f.isRetryable(taskErr) {
+		return lis.deadLetterTask(ctx, f, task, taskErr)
+	}
+
+	if f.retryPolicy == nil {
+		return false, nil
+	}
+
+	if task.Retry+1 >= f.retryPolicy.MaxAttempts {
+		return lis.deadLetterTask(ctx, f, task, taskErr)
+	}
+
+	// The deduplication key is not sent again, otherwise the server would discard
+	// the retry as a duplicate of the original task.
+	eta := time.Now().Add(f.retryPolicy.backoff(task.Retry))
+	retry := &pb.SendTask{
+		Payload:  task.Payload,
+		MinEta:   datetime.SerializeTimestamp(eta),
+		Retry:    task.Retry + 1,
+		Headers:  task.Headers,
+		Priority: task.Priority,
+		Ttl:      retryTTL(task, eta),
+		Tags:     task.Tags,
+	}
+	lis.callbacks.taskRetried(ctx, task, retry.Retry, eta)
+	if err := queue.SendTasks(ctx, []*pb.SendTask{retry}); err != nil {
+		return false, fmt.Errorf("delay: cannot retry task: %w", err)
+	}
+	lis.metrics.TaskRetried(queue.name, f.key, retry.Retry)
+
+	return true, nil
+}
+
+// Headers added to the tasks sent to the dead-letter queue.
+const (
+	HeaderDeadLetterError    = "delay-dead-letter-error"
+	HeaderDeadLetterRetry    = "delay-dead-letter-retry"
+	HeaderDeadLetterQueue    = "delay-dead-letter-queue"
+	HeaderDeadLetterFunction = "delay-dead-letter-function"
+)
+
+// deadLetterHeaders copies the headers of the task adding the details of the failure.
+func deadLetterHeaders(task *pb.Task, taskErr error) map[string]string {
+	headers := make(map[string]string, len(task.Headers)+4)
+	for k, v := range task.Headers {
+		headers[k] = v
+	}
+	headers[HeaderDeadLetterError] = taskErr.Error()
+	headers[HeaderDeadLetterRetry] = strconv.FormatInt(int64(task.Retry), 10)
+	headers[HeaderDeadLetterQueue] = task.QueueName
+
+	return headers
+}
+
+// deadLetterTask sends a task that exhausted all its retries to the dead-letter
+// queue. It returns true if the task was enqueued there.
+func (lis *Listener) deadLetterTask(ctx context.Context, f *Function, task *pb.Task, taskErr error) (bool, error) {
+	dlq := lis.deadLetter
+	if f.deadLetter != nil {
+		dlq = f.deadLetter
+	}
+
+	reason := "Task exhausted all retry attempts"
+	if !f.isRetryable(taskErr) {
+		reason = "Task failed with a non retryable error"
+	}
+	if dlq == nil {
+		lis.logger.WithFields(log.Fields{
+			"project": task.Project,
+			"queue":   task.QueueName,
+			"task":    task.Code,
+			"retry":   task.Retry,
+		}).Error(reason)
+
+		return false, nil
+	}
+
+	headers := deadLetterHeaders(task, taskErr)
+	headers[HeaderDeadLetterFunction] = f.key
+
+	dead := &pb.SendTask{
+		Payload: task.Payload,
+		Headers: headers,
+	}
+	if err := dlq.SendTasks(ctx, []*pb.SendTask{dead}); err != nil {
+		return false, fmt.Errorf("delay: cannot send task to the dead-letter queue: %w", err)
+	}
+	lis.audit.write(AuditDeadLettered, task, f, 0, taskErr)
+
+	lis.logger.WithFields(log.Fields{
+		"project": task.Project,
+		"queue":   task.QueueName,
+		"task":    task.Code,
+		"retry":   task.Retry,
+		"dlq":     dlq.name,
+	}).Error("Task exhausted all retry attempts, sent to the dead-letter queue")
+
+	return true, nil
+}
@@ -0,0 +1,178 @@
+package delay
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/altipla-consulting/datetime"
+
+	pb "github.com/altipla-consulting/delay/queues"
+)
+
+// InMemoryConn is a connection that stores the tasks in memory instead of sending
+// them to a queues server. It is designed for unit tests, tasks are only executed
+// when calling ProcessAll.
+//
+// The embedded connection can be used anywhere a *Conn is expected.
+type InMemoryConn struct {
+	*Conn
+
+	mu     sync.Mutex
+	queues map[string][]memoryTask
+	count  int64
+}
+
+// memoryTask is a pending task with the time it was sent to the queue.
+type memoryTask struct {
+	task    *pb.SendTask
+	created time.Time
+}
+
+// NewInMemoryConn creates a new connection that stores the tasks in memory.
+func NewInMemoryConn(project string) *InMemoryConn {
+	m := &InMemoryConn{
+		queues: make(map[string][]memoryTask),
+	}
+	m.Conn = &Conn{
+		project: project,
+		memory:  m,
+	}
+
+	return m
+}
+
+// Queue builds a new QueueSpec reference to a queue of the in-memory connection.
+func (m *InMemoryConn) Queue(name string) QueueSpec {
+	return Queue(m.Conn, name)
+}
+
+// Tasks returns the tasks that were sent to the queue and are still waiting to
+// be processed.
+func (m *InMemoryConn) Tasks(queueName string) []*pb.SendTask {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tasks := make([]*pb.SendTask, len(m.queues[queueName]))
+	for i, pending := range m.queues[queueName] {
+		tasks[i] = pending.task
+	}
+
+	return tasks
+}
+
+// Reset removes the pending tasks of all the queues.
+func (m *InMemoryConn) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.queues = make(map[string][]memoryTask)
+}
+
+func (m *InMemoryConn) sendTasks(queueName string, tasks []*pb.SendTask) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for _, task := range tasks {
+		m.queues[queueName] = append(m.queues[queueName], memoryTask{task: task, created: now})
+	}
+}
+
+func (m *InMemoryConn) purge(queueName string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	purged := int64(len(m.queues[queueName]))
+	delete(m.queues, queueName)
+
+	return purged
+}
+
+func (m *InMemoryConn) listQueues() []QueueInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	infos := make([]QueueInfo, 0, len(m.queues))
+	for name, tasks := range m.queues {
+		infos = append(infos, QueueInfo{
+			Name:   name,
+			Depth:  int64(len(tasks)),
+			Status: QueueActive,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+
+	return infos
+}
+
+// next extracts the first pending task of the queues, sorted by name.
+func (m *InMemoryConn) next() (string, *pb.Task) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.queues))
+	for name, tasks := range m.queues {
+		if len(tasks) > 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "", nil
+	}
+	sort.Strings(names)
+
+	name := names[0]
+	pending := m.queues[name][0]
+	m.queues[name] = m.queues[name][1:]
+	sendTask := pending.task
+
+	m.count++
+	task := &pb.Task{
+		Code:             fmt.Sprintf("mem-%d", m.count),
+		Payload:          sendTask.Payload,
+		Created:          datetime.SerializeTimestamp(pending.created),
+		Retry:            sendTask.Retry,
+		MinEta:           sendTask.MinEta,
+		Project:          m.project,
+		QueueName:        name,
+		Headers:          sendTask.Headers,
+		Priority:         sendTask.Priority,
+		DeduplicationKey: sendTask.DeduplicationKey,
+		Ttl:              sendTask.Ttl,
+		Tags:             sendTask.Tags,
+	}
+
+	return name, task
+}
+
+// ProcessAll executes synchronously all the pending tasks of the queues, including
+// the retries and the new tasks sent while running them, until no more tasks remain.
+// The ETA of the tasks is ignored. It returns the error of the first task that
+// failed and was not retried.
+//
+// The options configure the listener that runs the tasks, for example WithRegistry
+// to run the functions of other registry.
+func (m *InMemoryConn) ProcessAll(ctx context.Context, opts ...ListenerOption) error {
+	lis := NewListener(opts...)
+	// Stop the workers of the pool, no queue is being handled so it does not block.
+	defer lis.Stop(context.Background())
+
+	var first error
+	for {
+		name, task := m.next()
+		if task == nil {
+			return first
+		}
+
+		requeued, err := lis.handleTask(ctx, m.Queue(name), task)
+		lis.callbacks.taskCompleted(ctx, task, err)
+		if err != nil && (!requeued || IsNonRetryable(err)) && first == nil {
+			first = err
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package delay
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	delayprometheus "github.com/altipla-consulting/delay/prometheus"
+)
+
+// MetricsReporter receives the events of the tasks handled by the listener to
+// record them in a metrics backend. Functions are identified by their full key.
+type MetricsReporter interface {
+	// TaskReceived is called before running the function of the task.
+	TaskReceived(queue, function string)
+
+	// TaskSucceeded is called when the function of the task finishes without errors.
+	TaskSucceeded(queue, function string, duration time.Duration)
+
+	// TaskFailed is called when the function of the task returns an error. Retries
+	// is the number of times the task was retried before this execution and duration
+	// the time the function spent running until it failed.
+	TaskFailed(queue, function string, retries int32, duration time.Duration)
+
+	// TaskRetried is called when a failed task is sent again to the queue.
+	TaskRetried(queue, function string, retryCount int32)
+}
+
+// NoopMetricsReporter discards all the metrics. It is the default reporter of
+// the listeners.
+type NoopMetricsReporter struct{}
+
+// TaskReceived implements MetricsReporter.
+func (NoopMetricsReporter) TaskReceived(queue, function string) {}
+
+// TaskSucceeded implements MetricsReporter.
+func (NoopMetricsReporter) TaskSucceeded(queue, function string, duration time.Duration) {}
+
+// TaskFailed implements MetricsReporter.
+func (NoopMetricsReporter) TaskFailed(queue, function string, retries int32, duration time.Duration) {
+}
+
+// TaskRetried implements MetricsReporter.
+func (NoopMetricsReporter) TaskRetried(queue, function string, retryCount int32) {}
+
+// WithMetricsReporter sends the metrics of the tasks to the reporter.
+func WithMetricsReporter(reporter MetricsReporter) ListenerOption {
+	return func(lis *Listener) {
+		lis.metrics = reporter
+	}
+}
+
+// WithPrometheusRegisterer registers the metrics of the listener in Prometheus.
+// It is a shortcut of WithMetricsReporter(prometheus.NewMetricsReporter(r)) using
+// the sub-package github.com/altipla-consulting/delay/prometheus.
+func WithPrometheusRegisterer(r prometheus.Registerer) ListenerOption {
+	return WithMetricsReporter(delayprometheus.NewMetricsReporter(r))
+}
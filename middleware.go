@@ -0,0 +1,83 @@
+package delay
+
+import (
+	"context"
+
+	altiplaerrors "github.com/altipla-consulting/errors"
+	log "github.com/sirupsen/logrus"
+
+	pb "github.com/altipla-consulting/delay/queues"
+)
+
+// HandlerMiddleware runs around the execution of every task. It should call next
+// to continue with the chain and finally run the function of the task. The
+// middleware can change the context or the error returned by the rest of the chain.
+type HandlerMiddleware func(ctx context.Context, task *pb.Task, next func(context.Context) error) error
+
+// Use registers middlewares that will run around every task handled by the listener.
+// They run in the same order they are registered, after the built-in middlewares
+// that report the errors.
+func (lis *Listener) Use(mw ...HandlerMiddleware) {
+	lis.middlewares = append(lis.middlewares, mw...)
+}
+
+// Wrap adds middlewares that only run around the tasks of the function. They run
+// before the middlewares registered in the listener with Use, once the function of
+// the task is known, and after the built-in middlewares that report the errors.
+// Middlewares of later calls run before the ones of previous calls.
+func (f *Function) Wrap(mw ...HandlerMiddleware) *Function {
+	f.middlewares = append(append([]HandlerMiddleware(nil), mw...), f.middlewares...)
+	return f
+}
+
+// runMiddlewares calls handler wrapped by all the middlewares of the listener.
+func (lis *Listener) runMiddlewares(ctx context.Context, task *pb.Task, handler func(ctx context.Context) error) error {
+	return runMiddlewares(ctx, lis.middlewares, task, handler)
+}
+
+// runFunctionMiddlewares calls handler wrapped by the built-in middlewares of the
+// listener, then the middlewares of the function and then the rest of middlewares
+// of the listener.
+func (lis *Listener) runFunctionMiddlewares(ctx context.Context, f *Function, task *pb.Task, handler func(ctx context.Context) error) error {
+	builtin, registered := lis.middlewares[:1], lis.middlewares[1:]
+	middlewares := make([]HandlerMiddleware, 0, len(lis.middlewares)+len(f.middlewares))
+	middlewares = append(middlewares, builtin...)
+	middlewares = append(middlewares, f.middlewares...)
+	middlewares = append(middlewares, registered...)
+
+	return runMiddlewares(ctx, middlewares, task, handler)
+}
+
+// runMiddlewares calls handler wrapped by the middlewares in order.
+func runMiddlewares(ctx context.Context, middlewares []HandlerMiddleware, task *pb.Task, handler func(ctx context.Context) error) error {
+	next := handler
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		mw, inner := middlewares[i], next
+		next = func(ctx context.Context) error {
+			return mw(ctx, task, inner)
+		}
+	}
+
+	return next(ctx)
+}
+
+// reportErrors logs the failed tasks and sends them to the error reporter if configured.
+func (lis *Listener) reportErrors(ctx context.Context, task *pb.Task, next func(context.Context) error) error {
+	err := next(ctx)
+	if err != nil {
+		lis.logger.WithFields(log.Fields{
+			"error":   err.Error(),
+			"details": altiplaerrors.Details(err),
+			"project": task.Project,
+			"queue":   task.QueueName,
+			"task":    task.Code,
+			"headers": task.Headers,
+		}).Error("Task handler failed")
+
+		if lis.errorReporter != nil {
+			lis.errorReporter.Report(ctx, err)
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,113 @@
+package delay
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MigrationFn transforms the arguments of a task encoded with an old schema version
+// of the function to the current one. It receives and returns the invocation
+// encoded with the codec of the function, without compression nor encryption.
+type MigrationFn func(data []byte) ([]byte, error)
+
+// WithSchemaVersion sets the version of the arguments of the function. It is sent
+// inside the tasks and should be increased each time the arguments change in a way
+// the old tasks cannot be decoded anymore. Tasks without version have version zero.
+//
+// Versions other than zero are added to the key of the function with the suffix
+// "@v" and the number, for example "key@v2". Multiple versions of the same function
+// can be registered at the same time and the tasks run in the function of their
+// version. Tasks of versions no longer registered are migrated to the latest one
+// with the functions of WithMigration.
+func WithSchemaVersion(v int) FuncOption {
+	return func(f *Function) {
+		f.schemaVersion = v
+	}
+}
+
+// WithMigration registers the migration of the tasks encoded with the version from
+// to the current schema version of the function. It is applied before decoding the
+// arguments of the tasks sent with that version, so the queues do not need to be
+// drained when changing the arguments.
+func WithMigration(from int, fn MigrationFn) FuncOption {
+	return func(f *Function) {
+		if f.migrations == nil {
+			f.migrations = make(map[int]MigrationFn)
+		}
+		f.migrations[from] = fn
+	}
+}
+
+// invocationHeader decodes the fields of an invocation without its arguments, that
+// may not be decodable before migrating them.
+type invocationHeader struct {
+	Key     string
+	Version int
+}
+
+// decodeInvocation decodes the payload and finds the function of the invocation,
+// migrating the arguments if they were encoded with other schema version.
+func (lis *Listener) decodeInvocation(payload []byte) (*Function, Codec, invocation, error) {
+	var inv invocation
+
+	codec, data, err := splitPayload(payload)
+	if err != nil {
+		return nil, nil, inv, fmt.Errorf("delay: cannot decode call: %w", err)
+	}
+	decodeErr := codec.Decode(data, &inv)
+	key, version := inv.Key, inv.Version
+	if decodeErr != nil {
+		var header invocationHeader
+		if err := codec.Decode(data, &header); err != nil {
+			return nil, nil, inv, fmt.Errorf("delay: cannot decode call: %w", decodeErr)
+		}
+		key, version = header.Key, header.Version
+	}
+
+	f := lis.registry.lookup(key)
+	if f == nil {
+		f = lis.registry.lookupLatest(baseKey(key))
+	}
+	if f == nil {
+		return nil, nil, inv, fmt.Errorf("delay: no func with key %q found", key)
+	}
+	if version == f.schemaVersion {
+		if decodeErr != nil {
+			return f, nil, inv, fmt.Errorf("delay: cannot decode call: %w", decodeErr)
+		}
+		return f, codec, inv, nil
+	}
+
+	migrate := f.migrations[version]
+	if migrate == nil {
+		return f, nil, inv, fmt.Errorf("delay: no migration from schema version %d to %d", version, f.schemaVersion)
+	}
+	data, err = migrate(data)
+	if err != nil {
+		return f, nil, inv, fmt.Errorf("delay: cannot migrate call from schema version %d: %w", version, err)
+	}
+	inv = invocation{}
+	if err := codec.Decode(data, &inv); err != nil {
+		return f, nil, inv, fmt.Errorf("delay: cannot decode migrated call: %w", err)
+	}
+
+	return f, codec, inv, nil
+}
+
+func versionedKey(key string, version int) string {
+	return key + "@v" + strconv.Itoa(version)
+}
+
+// baseKey removes the schema version from the key of a function.
+func baseKey(key string) string {
+	i := strings.LastIndex(key, "@v")
+	if i < 0 {
+		return key
+	}
+	if _, err := strconv.Atoi(key[i+2:]); err != nil {
+		return key
+	}
+
+	return key[:i]
+}
@@ -0,0 +1,277 @@
+package delay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/altipla-consulting/datetime"
+	"github.com/golang/protobuf/proto"
+	"github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
+
+	pb "github.com/altipla-consulting/delay/queues"
+)
+
+const (
+	// Time a received message waits for its ack before it is delivered again. The
+	// listener extends it periodically while the task is running.
+	natsAckWait = 30 * time.Second
+
+	// Delay of the first delivery of a failed task, doubled in each delivery up
+	// to the maximum.
+	natsRetryDelay    = time.Second
+	natsMaxRetryDelay = 10 * time.Minute
+
+	// Deliveries of a failed task before sending it to the subject of the queue
+	// with the suffix "-dlq".
+	natsMaxDeliveries = 10
+)
+
+type natsBackend struct {
+	nc     *nats.Conn
+	js     nats.JetStreamContext
+	stream string
+}
+
+// NewConnNATS creates a connection that stores the tasks in NATS JetStream. Each
+// queue is the subject "<stream>.<name>" of the stream, that is created if it does
+// not exist yet with the work queue retention policy.
+//
+// All the listeners of a queue share the durable consumer "delay-<name>". Tasks
+// that fail without a retry policy are delivered again with an increasing delay,
+// up to 10 times before sending them to the queue "<name>-dlq".
+func NewConnNATS(url string, streamName string, opts ...nats.Option) (*Conn, error) {
+	if streamName == "" {
+		return nil, fmt.Errorf("delay: nats stream name required")
+	}
+
+	nc, err := nats.Connect(url, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("delay: cannot connect to nats: %w", err)
+	}
+	js, err := nc.JetStream()
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("delay: cannot connect to nats jetstream: %w", err)
+	}
+
+	if _, err := js.StreamInfo(streamName); err != nil {
+		if !errors.Is(err, nats.ErrStreamNotFound) {
+			nc.Close()
+			return nil, fmt.Errorf("delay: cannot read nats stream: %w", err)
+		}
+		_, err := js.AddStream(&nats.StreamConfig{
+			Name:      streamName,
+			Subjects:  []string{streamName + ".>"},
+			Retention: nats.WorkQueuePolicy,
+		})
+		if err != nil {
+			nc.Close()
+			return nil, fmt.Errorf("delay: cannot create nats stream: %w", err)
+		}
+	}
+
+	return &Conn{
+		backend: &natsBackend{
+			nc:     nc,
+			js:     js,
+			stream: streamName,
+		},
+	}, nil
+}
+
+func (b *natsBackend) subject(queueName string) string {
+	return b.stream + "." + queueName
+}
+
+func (b *natsBackend) sendTasks(ctx context.Context, queueName string, tasks []*pb.SendTask) error {
+	for _, task := range tasks {
+		encoded, err := proto.Marshal(task)
+		if err != nil {
+			return fmt.Errorf("delay: cannot encode task: %w", err)
+		}
+		msg := nats.NewMsg(b.subject(queueName))
+		msg.Data = encoded
+		if task.DeduplicationKey != "" {
+			msg.Header.Set(nats.MsgIdHdr, task.DeduplicationKey)
+		}
+
+		if _, err := b.js.PublishMsg(msg, nats.Context(ctx)); err != nil {
+			return fmt.Errorf("delay: cannot send tasks to nats: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (b *natsBackend) listen(ctx context.Context, queueName string, handler func(task *pb.Task) error) error {
+	// Tasks run in their own goroutine, the ones received while stopping are
+	// rejected to deliver them to other listener.
+	var mu sync.Mutex
+	var stopped bool
+	var running sync.WaitGroup
+
+	durable := "delay-" + queueName
+	sub, err := b.js.QueueSubscribe(b.subject(queueName), durable, func(msg *nats.Msg) {
+		mu.Lock()
+		if stopped {
+			mu.Unlock()
+			msg.Nak()
+			return
+		}
+		running.Add(1)
+		mu.Unlock()
+
+		go func() {
+			defer running.Done()
+			b.handleMsg(queueName, msg, handler)
+		}()
+	}, nats.Durable(durable), nats.BindStream(b.stream), nats.ManualAck(), nats.AckExplicit(), nats.AckWait(natsAckWait),
+		// Backstop in case the task cannot be sent to the dead-letter queue. The
+		// delivery that waits for the ETA does not count.
+		nats.MaxDeliver(natsMaxDeliveries+1))
+	if err != nil {
+		return fmt.Errorf("delay: cannot listen to nats: %w", err)
+	}
+
+	<-ctx.Done()
+
+	mu.Lock()
+	stopped = true
+	mu.Unlock()
+	if err := sub.Unsubscribe(); err != nil && !errors.Is(err, nats.ErrConnectionClosed) {
+		return fmt.Errorf("delay: cannot stop listening to nats: %w", err)
+	}
+	running.Wait()
+
+	return nil
+}
+
+func (b *natsBackend) handleMsg(queueName string, msg *nats.Msg, handler func(task *pb.Task) error) {
+	logger := log.WithField("queue", queueName)
+
+	meta, err := msg.Metadata()
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("Cannot read nats message metadata")
+		msg.Term()
+		return
+	}
+	sendTask := new(pb.SendTask)
+	if err := proto.Unmarshal(msg.Data, sendTask); err != nil {
+		logger.WithField("error", err.Error()).Error("Cannot decode incoming task")
+		msg.Term()
+		return
+	}
+
+	// Tasks sent with an ETA in the future were delivered once before to wait for it.
+	eta := datetime.ParseTimestamp(sendTask.MinEta)
+	failures := int64(meta.NumDelivered) - 1
+	if eta.After(meta.Timestamp) && failures > 0 {
+		failures--
+	}
+
+	task := &pb.Task{
+		Code:             fmt.Sprintf("%s-%d", meta.Stream, meta.Sequence.Stream),
+		Payload:          sendTask.Payload,
+		Created:          datetime.SerializeTimestamp(meta.Timestamp),
+		Retry:            sendTask.Retry + int32(failures),
+		MinEta:           sendTask.MinEta,
+		Headers:          sendTask.Headers,
+		Priority:         sendTask.Priority,
+		DeduplicationKey: sendTask.DeduplicationKey,
+		Ttl:              sendTask.Ttl,
+		Tags:             sendTask.Tags,
+	}
+	logger = logger.WithField("task", task.Code)
+
+	if wait := time.Until(eta); wait > 0 {
+		if err := msg.NakWithDelay(wait); err != nil {
+			logger.WithField("error", err.Error()).Error("Cannot delay task")
+		}
+		return
+	}
+
+	// Extend the ack deadline while the task is running.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(natsAckWait / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := msg.InProgress(); err != nil {
+					logger.WithField("error", err.Error()).Warning("Cannot extend the ack deadline of the task")
+				}
+			}
+		}
+	}()
+
+	if err := handler(task); err != nil {
+		if failures+1 >= natsMaxDeliveries {
+			b.deadLetter(queueName, task, err, msg)
+			return
+		}
+
+		delay := natsRetryDelay << uint(failures)
+		if delay <= 0 || delay > natsMaxRetryDelay {
+			delay = natsMaxRetryDelay
+		}
+		if err := msg.NakWithDelay(delay); err != nil {
+			logger.WithField("error", err.Error()).Error("Cannot reject failed task")
+		}
+		return
+	}
+	if err := msg.Ack(); err != nil {
+		logger.WithField("error", err.Error()).Error("Cannot ack task")
+	}
+}
+
+// deadLetter sends a task that failed too many times to the dead-letter queue
+// and removes it from the stream.
+func (b *natsBackend) deadLetter(queueName string, task *pb.Task, taskErr error, msg *nats.Msg) {
+	logger := log.WithFields(log.Fields{
+		"queue": queueName,
+		"task":  task.Code,
+	})
+
+	dlq := queueName + "-dlq"
+	err := b.sendTasks(context.Background(), dlq, []*pb.SendTask{{
+		Payload: task.Payload,
+		Headers: deadLetterHeaders(task, taskErr),
+	}})
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("Cannot send task to the dead-letter queue")
+		msg.Nak()
+		return
+	}
+	if err := msg.Term(); err != nil {
+		logger.WithField("error", err.Error()).Error("Cannot remove dead-lettered task")
+	}
+
+	logger.WithFields(log.Fields{
+		"retry": task.Retry,
+		"dlq":   dlq,
+	}).Error("Task exhausted all retry attempts, sent to the dead-letter queue")
+}
+
+func (b *natsBackend) ping(ctx context.Context) error {
+	if err := b.nc.FlushWithContext(ctx); err != nil {
+		return fmt.Errorf("delay: cannot ping nats: %w", err)
+	}
+	if _, err := b.js.StreamInfo(b.stream, nats.Context(ctx)); err != nil {
+		return fmt.Errorf("delay: cannot ping nats: %w", err)
+	}
+
+	return nil
+}
+
+func (b *natsBackend) close() error {
+	b.nc.Close()
+	return nil
+}
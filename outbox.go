@@ -0,0 +1,177 @@
+package delay
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	log "github.com/sirupsen/logrus"
+
+	pb "github.com/altipla-consulting/delay/queues"
+)
+
+// Default values of the outbox publishers.
+const (
+	DefaultOutboxPollInterval = 5 * time.Second
+	DefaultOutboxBatchSize    = 100
+)
+
+// OutboxPublisher saves the tasks in a table of the database inside the transactions
+// of the application and sends them later to the queue. Tasks are only sent if
+// the transaction that saved them commits.
+//
+// The queries use the MySQL syntax. The table should be created before with the
+// statement returned by DDL.
+type OutboxPublisher struct {
+	db           *sql.DB
+	tableName    string
+	queue        QueueSpec
+	pollInterval time.Duration
+	batchSize    int
+}
+
+// NewOutboxPublisher creates a publisher that saves the tasks in the table and
+// sends them to the queue. The table name is written as is in the queries and it
+// should not come from user input.
+func NewOutboxPublisher(db *sql.DB, tableName string, queue QueueSpec) *OutboxPublisher {
+	return &OutboxPublisher{
+		db:           db,
+		tableName:    tableName,
+		queue:        queue,
+		pollInterval: DefaultOutboxPollInterval,
+		batchSize:    DefaultOutboxBatchSize,
+	}
+}
+
+// WithPollInterval changes the time Run waits before checking again the table when
+// there are no pending tasks.
+func (op *OutboxPublisher) WithPollInterval(d time.Duration) *OutboxPublisher {
+	op.pollInterval = d
+	return op
+}
+
+// WithBatchSize changes the maximum number of tasks sent to the queue in each request.
+func (op *OutboxPublisher) WithBatchSize(n int) *OutboxPublisher {
+	op.batchSize = n
+	return op
+}
+
+// DDL returns the statement that creates the outbox table.
+func (op *OutboxPublisher) DDL() string {
+	return fmt.Sprintf(`CREATE TABLE %s (
+  id BIGINT NOT NULL AUTO_INCREMENT,
+  task BLOB NOT NULL,
+  created_at DATETIME NOT NULL,
+  published_at DATETIME NULL,
+
+  PRIMARY KEY (id),
+  KEY published_at (published_at)
+)`, op.tableName)
+}
+
+// Enqueue saves the task in the outbox table inside the transaction. The trace and
+// the correlation ID of the context are saved with the task.
+func (op *OutboxPublisher) Enqueue(ctx context.Context, tx *sql.Tx, task *pb.SendTask) error {
+	injectTraceContext(ctx, task)
+	injectCorrelationID(ctx, task)
+	data, err := proto.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("delay: cannot encode task: %w", err)
+	}
+
+	q := fmt.Sprintf("INSERT INTO %s (task, created_at) VALUES (?, ?)", op.tableName)
+	if _, err := tx.ExecContext(ctx, q, data, time.Now().UTC()); err != nil {
+		return fmt.Errorf("delay: cannot save task in the outbox: %w", err)
+	}
+
+	return nil
+}
+
+// Run sends the pending tasks of the outbox table until the context is cancelled.
+// Errors are logged and the tasks sent again in the next iteration, so the functions
+// may receive the same task more than once.
+func (op *OutboxPublisher) Run(ctx context.Context) {
+	for {
+		n, err := op.publish(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.WithFields(log.Fields{
+				"error": err.Error(),
+				"table": op.tableName,
+				"queue": op.queue.name,
+			}).Error("Cannot publish outbox tasks")
+		}
+
+		// Continue immediately if there are more pending tasks.
+		if err == nil && n >= op.batchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(op.pollInterval):
+		}
+	}
+}
+
+// publish sends a batch of pending tasks and returns how many of them were sent.
+func (op *OutboxPublisher) publish(ctx context.Context) (int, error) {
+	tx, err := op.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("delay: cannot begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the rows to avoid sending the same tasks from multiple instances.
+	q := fmt.Sprintf("SELECT id, task FROM %s WHERE published_at IS NULL ORDER BY id LIMIT %d FOR UPDATE", op.tableName, op.batchSize)
+	rows, err := tx.QueryContext(ctx, q)
+	if err != nil {
+		return 0, fmt.Errorf("delay: cannot read outbox: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []interface{}
+	var tasks []*pb.SendTask
+	for rows.Next() {
+		var id int64
+		var data []byte
+		if err := rows.Scan(&id, &data); err != nil {
+			return 0, fmt.Errorf("delay: cannot read outbox: %w", err)
+		}
+		task := new(pb.SendTask)
+		if err := proto.Unmarshal(data, task); err != nil {
+			return 0, fmt.Errorf("delay: cannot decode outbox task %d: %w", id, err)
+		}
+		ids = append(ids, id)
+		tasks = append(tasks, task)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("delay: cannot read outbox: %w", err)
+	}
+	rows.Close()
+
+	if len(tasks) == 0 {
+		return 0, nil
+	}
+	if err := op.queue.SendTasks(ctx, tasks); err != nil {
+		return 0, err
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	q = fmt.Sprintf("UPDATE %s SET published_at = ? WHERE id IN (%s)", op.tableName, placeholders)
+	args := append([]interface{}{time.Now().UTC()}, ids...)
+	if _, err := tx.ExecContext(ctx, q, args...); err != nil {
+		return 0, fmt.Errorf("delay: cannot mark outbox tasks as published: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("delay: cannot mark outbox tasks as published: %w", err)
+	}
+
+	return len(tasks), nil
+}
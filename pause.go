@@ -0,0 +1,62 @@
+package delay
+
+import (
+	"context"
+)
+
+// Pause stops sending new tasks to the workers until calling Resume. The tasks
+// already running finish normally and the connections with the queues stay open,
+// the tasks received during the pause wait in order until resuming.
+//
+// Calling Pause when the listener is already paused does nothing.
+func (lis *Listener) Pause() {
+	lis.pauseMu.Lock()
+	defer lis.pauseMu.Unlock()
+
+	if lis.resumed == nil {
+		lis.resumed = make(chan struct{})
+		lis.logger.Info("Listener paused")
+	}
+}
+
+// Resume sends again the tasks to the workers after a call to Pause.
+//
+// Calling Resume when the listener is not paused does nothing.
+func (lis *Listener) Resume() {
+	lis.pauseMu.Lock()
+	defer lis.pauseMu.Unlock()
+
+	if lis.resumed != nil {
+		close(lis.resumed)
+		lis.resumed = nil
+		lis.logger.Info("Listener resumed")
+	}
+}
+
+// IsPaused returns true if the listener is paused.
+func (lis *Listener) IsPaused() bool {
+	lis.pauseMu.Lock()
+	defer lis.pauseMu.Unlock()
+
+	return lis.resumed != nil
+}
+
+// waitResumed blocks while the listener is paused. It returns false if the listener
+// starts stopping or the context is cancelled before resuming.
+func (lis *Listener) waitResumed(ctx context.Context) bool {
+	lis.pauseMu.Lock()
+	resumed := lis.resumed
+	lis.pauseMu.Unlock()
+
+	if resumed == nil {
+		return true
+	}
+	select {
+	case <-resumed:
+		return true
+	case <-lis.stopping:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
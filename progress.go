@@ -0,0 +1,116 @@
+package delay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// ErrNoProgress is returned by GetProgress when the task did not report progress
+// or it already expired.
+var ErrNoProgress = errors.New("delay: no progress reported")
+
+// Prefix of the Redis keys where RedisProgressReporter saves the progress.
+const redisProgressPrefix = "delay-progress:"
+
+// ProgressReporter saves the progress of the running tasks so it can be read from
+// outside the listener.
+type ProgressReporter interface {
+	Report(taskCode string, fraction float64, message string) error
+}
+
+// WithProgressReporter returns a new context with the reporter used by ReportProgress.
+// It can be called inside a hook of Listener.OnTask to configure all the tasks.
+func WithProgressReporter(ctx context.Context, pr ProgressReporter) context.Context {
+	return context.WithValue(ctx, keyProgressReporter, pr)
+}
+
+// ReportProgress saves the progress of the running task with the reporter of the
+// context. The fraction should be between 0 and 1. It does nothing outside of a
+// task handler or if the context has no reporter.
+func ReportProgress(ctx context.Context, fraction float64, message string) error {
+	pr, _ := ctx.Value(keyProgressReporter).(ProgressReporter)
+	code := TaskCodeFromContext(ctx)
+	if pr == nil || code == "" {
+		return nil
+	}
+	if fraction < 0 || fraction > 1 {
+		return fmt.Errorf("delay: progress fraction out of range: %v", fraction)
+	}
+
+	if err := pr.Report(code, fraction, message); err != nil {
+		return fmt.Errorf("delay: cannot report progress: %w", err)
+	}
+
+	return nil
+}
+
+// Progress is the last progress reported by a task.
+type Progress struct {
+	Fraction  float64
+	Message   string
+	UpdatedAt time.Time
+}
+
+// RedisProgressReporter saves the progress of the tasks in a Redis hash for each
+// task that expires after a while. Use GetProgress to read it.
+type RedisProgressReporter struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// NewRedisProgressReporter creates a reporter that saves the progress in Redis
+// during ttl since the last report. Keys are prefixed with "delay-progress:".
+func NewRedisProgressReporter(client *redis.Client, ttl time.Duration) *RedisProgressReporter {
+	return &RedisProgressReporter{
+		client: client,
+		ttl:    ttl,
+	}
+}
+
+// Report implements ProgressReporter.
+func (pr *RedisProgressReporter) Report(taskCode string, fraction float64, message string) error {
+	key := redisProgressPrefix + taskCode
+	_, err := pr.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HMSet(key, map[string]interface{}{
+			"fraction": strconv.FormatFloat(fraction, 'f', -1, 64),
+			"message":  message,
+			"updated":  time.Now().UnixNano(),
+		})
+		pipe.Expire(key, pr.ttl)
+		return nil
+	})
+
+	return err
+}
+
+// GetProgress reads the last progress saved by RedisProgressReporter for the task.
+// It returns ErrNoProgress if there is none.
+func GetProgress(ctx context.Context, client *redis.Client, taskCode string) (*Progress, error) {
+	values, err := client.WithContext(ctx).HGetAll(redisProgressPrefix + taskCode).Result()
+	if err != nil {
+		return nil, fmt.Errorf("delay: cannot read progress: %w", err)
+	}
+	if len(values) == 0 {
+		return nil, ErrNoProgress
+	}
+
+	fraction, err := strconv.ParseFloat(values["fraction"], 64)
+	if err != nil {
+		return nil, fmt.Errorf("delay: cannot read progress: %w", err)
+	}
+	updated, err := strconv.ParseInt(values["updated"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("delay: cannot read progress: %w", err)
+	}
+
+	return &Progress{
+		Fraction:  fraction,
+		Message:   values["message"],
+		UpdatedAt: time.Unix(0, updated),
+	}, nil
+}
@@ -0,0 +1,100 @@
+// Package prometheus exports the metrics of the delay listeners to Prometheus.
+package prometheus
+
+import (
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Results of the tasks in the metrics.
+const (
+	resultSuccess = "success"
+	resultFailed  = "failed"
+)
+
+// PrometheusMetricsReporter records the metrics of the tasks in Prometheus. It
+// implements the delay.MetricsReporter interface.
+type PrometheusMetricsReporter struct {
+	tasks    *prometheus.CounterVec
+	retries  *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	failures *prometheus.HistogramVec
+	active   prometheus.Gauge
+}
+
+// NewMetricsReporter registers the metrics of the tasks in Prometheus. If the
+// metrics were already registered by another reporter they will be shared.
+func NewMetricsReporter(r prometheus.Registerer) *PrometheusMetricsReporter {
+	return &PrometheusMetricsReporter{
+		tasks: registerCollector(r, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "delay_tasks_total",
+			Help: "Number of tasks received by the listener.",
+		}, []string{"function", "queue", "result"})).(*prometheus.CounterVec),
+		retries: registerCollector(r, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "delay_task_retries_total",
+			Help: "Number of failed tasks sent again to the queue by the listener.",
+		}, []string{"function", "queue"})).(*prometheus.CounterVec),
+		duration: registerCollector(r, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "delay_task_duration_seconds",
+			Help: "Execution latency of the successful tasks.",
+		}, []string{"function"})).(*prometheus.HistogramVec),
+		failures: registerCollector(r, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "delay_task_failure_duration_seconds",
+			Help: "Execution latency of the failed tasks.",
+		}, []string{"function"})).(*prometheus.HistogramVec),
+		active: registerCollector(r, prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "delay_active_tasks",
+			Help: "Number of tasks running right now.",
+		})).(prometheus.Gauge),
+	}
+}
+
+func registerCollector(r prometheus.Registerer, c prometheus.Collector) prometheus.Collector {
+	if err := r.Register(c); err != nil {
+		if already, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return already.ExistingCollector
+		}
+		panic(err)
+	}
+
+	return c
+}
+
+// TaskReceived implements delay.MetricsReporter.
+func (reporter *PrometheusMetricsReporter) TaskReceived(queue, function string) {
+	reporter.active.Inc()
+}
+
+// TaskSucceeded implements delay.MetricsReporter.
+func (reporter *PrometheusMetricsReporter) TaskSucceeded(queue, function string, duration time.Duration) {
+	function = metricsLabel(function)
+	reporter.active.Dec()
+	reporter.tasks.WithLabelValues(function, queue, resultSuccess).Inc()
+	reporter.duration.WithLabelValues(function).Observe(duration.Seconds())
+}
+
+// TaskFailed implements delay.MetricsReporter.
+func (reporter *PrometheusMetricsReporter) TaskFailed(queue, function string, retries int32, duration time.Duration) {
+	function = metricsLabel(function)
+	reporter.active.Dec()
+	reporter.tasks.WithLabelValues(function, queue, resultFailed).Inc()
+	reporter.failures.WithLabelValues(function).Observe(duration.Seconds())
+}
+
+// TaskRetried implements delay.MetricsReporter.
+func (reporter *PrometheusMetricsReporter) TaskRetried(queue, function string, retryCount int32) {
+	reporter.retries.WithLabelValues(metricsLabel(function), queue).Inc()
+}
+
+// metricsLabel simplifies the function keys, that contain the full path of the
+// source file, to a value that is safe to use and read in dashboards.
+func metricsLabel(key string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, strings.TrimLeft(key, "/"))
+}
@@ -0,0 +1,231 @@
+package delay
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/altipla-consulting/datetime"
+	"github.com/golang/protobuf/proto"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/altipla-consulting/delay/queues"
+)
+
+// Time the subscriptions created automatically wait for the ack of a task before
+// sending it again. The client extends it while the task is running.
+const pubsubAckDeadline = 60 * time.Second
+
+// Maximum time the listener keeps a task waiting for its ETA. Tasks with a later ETA
+// are published again after this time, well before the client stops extending the
+// ack deadline.
+const pubsubMaxHold = 5 * time.Minute
+
+type pubsubBackend struct {
+	client *pubsub.Client
+
+	mu     sync.Mutex
+	topics map[string]*pubsub.Topic
+}
+
+// NewConnPubSub creates a connection that stores the tasks in Google Cloud Pub/Sub.
+// Each queue sends the tasks to the topic "delay-<name>" and receives them from the
+// subscription "delay-<name>-sub". Both of them are created if they do not exist.
+//
+// Pub/Sub cannot delay messages, tasks with an ETA in the future are received and
+// kept by the listener until the ETA arrives, up to 5 minutes. Tasks with a later
+// ETA are sent again to the topic after that time. Messages that cannot be decoded
+// are logged and discarded.
+func NewConnPubSub(ctx context.Context, gcpProject string, opts ...option.ClientOption) (*Conn, error) {
+	client, err := pubsub.NewClient(ctx, gcpProject, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("delay: cannot connect to Pub/Sub: %w", err)
+	}
+
+	return &Conn{
+		project: gcpProject,
+		backend: &pubsubBackend{
+			client: client,
+			topics: make(map[string]*pubsub.Topic),
+		},
+	}, nil
+}
+
+// topic returns the topic of the queue, creating it if needed on first use.
+func (b *pubsubBackend) topic(ctx context.Context, queueName string) (*pubsub.Topic, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if topic, ok := b.topics[queueName]; ok {
+		return topic, nil
+	}
+
+	id := "delay-" + queueName
+	topic := b.client.Topic(id)
+	exists, err := topic.Exists(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("delay: cannot check topic %s: %w", id, err)
+	}
+	if !exists {
+		// Other instance could create it at the same time.
+		topic, err = b.client.CreateTopic(ctx, id)
+		if status.Code(err) == codes.AlreadyExists {
+			topic, err = b.client.Topic(id), nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("delay: cannot create topic %s: %w", id, err)
+		}
+	}
+	b.topics[queueName] = topic
+
+	return topic, nil
+}
+
+func (b *pubsubBackend) subscription(ctx context.Context, queueName string) (*pubsub.Subscription, error) {
+	topic, err := b.topic(ctx, queueName)
+	if err != nil {
+		return nil, err
+	}
+
+	id := "delay-" + queueName + "-sub"
+	sub := b.client.Subscription(id)
+	exists, err := sub.Exists(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("delay: cannot check subscription %s: %w", id, err)
+	}
+	if !exists {
+		sub, err = b.client.CreateSubscription(ctx, id, pubsub.SubscriptionConfig{
+			Topic:       topic,
+			AckDeadline: pubsubAckDeadline,
+		})
+		if status.Code(err) == codes.AlreadyExists {
+			sub, err = b.client.Subscription(id), nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("delay: cannot create subscription %s: %w", id, err)
+		}
+	}
+
+	return sub, nil
+}
+
+func (b *pubsubBackend) sendTasks(ctx context.Context, queueName string, tasks []*pb.SendTask) error {
+	topic, err := b.topic(ctx, queueName)
+	if err != nil {
+		return err
+	}
+
+	results := make([]*pubsub.PublishResult, 0, len(tasks))
+	for _, task := range tasks {
+		encoded, err := proto.Marshal(task)
+		if err != nil {
+			return fmt.Errorf("delay: cannot encode task: %w", err)
+		}
+		results = append(results, topic.Publish(ctx, &pubsub.Message{Data: encoded}))
+	}
+	for _, result := range results {
+		if _, err := result.Get(ctx); err != nil {
+			return fmt.Errorf("delay: cannot publish task: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (b *pubsubBackend) listen(ctx context.Context, queueName string, handler func(task *pb.Task) error) error {
+	sub, err := b.subscription(ctx, queueName)
+	if err != nil {
+		return err
+	}
+
+	err = sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+		sendTask := new(pb.SendTask)
+		if err := proto.Unmarshal(msg.Data, sendTask); err != nil {
+			// Redelivering the message would fail again forever.
+			log.WithFields(log.Fields{
+				"queue": queueName,
+				"task":  msg.ID,
+				"error": err.Error(),
+			}).Error("Cannot decode incoming task, discarded")
+			msg.Ack()
+			return
+		}
+
+		// Keep the task until its ETA arrives, the client extends the ack deadline meanwhile.
+		if eta := time.Until(datetime.ParseTimestamp(sendTask.MinEta)); eta > 0 {
+			hold := eta
+			if hold > pubsubMaxHold {
+				hold = pubsubMaxHold
+			}
+			timer := time.NewTimer(hold)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				msg.Nack()
+				return
+			}
+
+			if hold < eta {
+				if err := b.sendTasks(context.Background(), queueName, []*pb.SendTask{sendTask}); err != nil {
+					log.WithFields(log.Fields{
+						"queue": queueName,
+						"task":  msg.ID,
+						"error": err.Error(),
+					}).Error("Cannot publish again the delayed task")
+					msg.Nack()
+					return
+				}
+				msg.Ack()
+				return
+			}
+		}
+
+		err := handler(&pb.Task{
+			Code:             msg.ID,
+			Payload:          sendTask.Payload,
+			Created:          datetime.SerializeTimestamp(msg.PublishTime),
+			Retry:            sendTask.Retry,
+			MinEta:           sendTask.MinEta,
+			Headers:          sendTask.Headers,
+			Priority:         sendTask.Priority,
+			DeduplicationKey: sendTask.DeduplicationKey,
+			Ttl:              sendTask.Ttl,
+			Tags:             sendTask.Tags,
+		})
+		if err != nil {
+			msg.Nack()
+			return
+		}
+		msg.Ack()
+	})
+	if err != nil {
+		return fmt.Errorf("delay: cannot receive tasks from Pub/Sub: %w", err)
+	}
+
+	return nil
+}
+
+func (b *pubsubBackend) ping(ctx context.Context) error {
+	if _, err := b.client.Topics(ctx).Next(); err != nil && err != iterator.Done {
+		return fmt.Errorf("delay: cannot ping Pub/Sub: %w", err)
+	}
+
+	return nil
+}
+
+func (b *pubsubBackend) close() error {
+	b.mu.Lock()
+	for _, topic := range b.topics {
+		topic.Stop()
+	}
+	b.mu.Unlock()
+
+	return b.client.Close()
+}
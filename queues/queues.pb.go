@@ -6,6 +6,7 @@ package queues_queues
 import (
 	fmt "fmt"
 	proto "github.com/golang/protobuf/proto"
+	duration "github.com/golang/protobuf/ptypes/duration"
 	timestamp "github.com/golang/protobuf/ptypes/timestamp"
 	context "golang.org/x/net/context"
 	_ "google.golang.org/genproto/googleapis/api/annotations"
@@ -52,6 +53,37 @@ func (Queue_Unit) EnumDescriptor() ([]byte, []int) {
 	return fileDescriptor_05add8dac95ef17c, []int{11, 0}
 }
 
+type Queue_Status int32
+
+const (
+	Queue_STATUS_UNKNOWN  Queue_Status = 0
+	Queue_STATUS_ACTIVE   Queue_Status = 1
+	Queue_STATUS_PAUSED   Queue_Status = 2
+	Queue_STATUS_DRAINING Queue_Status = 3
+)
+
+var Queue_Status_name = map[int32]string{
+	0: "STATUS_UNKNOWN",
+	1: "STATUS_ACTIVE",
+	2: "STATUS_PAUSED",
+	3: "STATUS_DRAINING",
+}
+
+var Queue_Status_value = map[string]int32{
+	"STATUS_UNKNOWN":  0,
+	"STATUS_ACTIVE":   1,
+	"STATUS_PAUSED":   2,
+	"STATUS_DRAINING": 3,
+}
+
+func (x Queue_Status) String() string {
+	return proto.EnumName(Queue_Status_name, int32(x))
+}
+
+func (Queue_Status) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_05add8dac95ef17c, []int{11, 1}
+}
+
 type ListenRequest struct {
 	// Types that are valid to be assigned to Request:
 	//  *ListenRequest_Initial
@@ -202,7 +234,11 @@ type ListenInitial struct {
 	// Código de proyecto.
 	Project string `protobuf:"bytes,1,opt,name=project,proto3" json:"project,omitempty"`
 	// Nombre de la cola.
-	QueueName            string   `protobuf:"bytes,2,opt,name=queue_name,json=queueName,proto3" json:"queue_name,omitempty"`
+	QueueName string `protobuf:"bytes,2,opt,name=queue_name,json=queueName,proto3" json:"queue_name,omitempty"`
+	// Si está activo el servidor entregará primero las tareas con mayor prioridad
+	// cuando haya varias disponibles, respetando el orden de llegada dentro de la
+	// misma prioridad.
+	PriorityOrdering     bool     `protobuf:"varint,3,opt,name=priority_ordering,json=priorityOrdering,proto3" json:"priority_ordering,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -247,6 +283,13 @@ func (m *ListenInitial) GetQueueName() string {
 	return ""
 }
 
+func (m *ListenInitial) GetPriorityOrdering() bool {
+	if m != nil {
+		return m.PriorityOrdering
+	}
+	return false
+}
+
 type Ack struct {
 	// Código de la tarea que confirmamos.
 	Code string `protobuf:"bytes,3,opt,name=code,proto3" json:"code,omitempty"`
@@ -352,7 +395,18 @@ type Task struct {
 	// Proyecto que ejecuta esta tarea.
 	Project string `protobuf:"bytes,6,opt,name=project,proto3" json:"project,omitempty"`
 	// Nombre de la cola que ejecuta esta tarea.
-	QueueName            string   `protobuf:"bytes,7,opt,name=queue_name,json=queueName,proto3" json:"queue_name,omitempty"`
+	QueueName string `protobuf:"bytes,7,opt,name=queue_name,json=queueName,proto3" json:"queue_name,omitempty"`
+	// Cabeceras con metadatos que se enviaron junto a la tarea.
+	Headers map[string]string `protobuf:"bytes,8,rep,name=headers,proto3" json:"headers,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// Prioridad de la tarea. Las tareas con mayor valor se ejecutan antes.
+	Priority int32 `protobuf:"varint,9,opt,name=priority,proto3" json:"priority,omitempty"`
+	// Clave de deduplicación con la que se envió la tarea.
+	DeduplicationKey string `protobuf:"bytes,10,opt,name=deduplication_key,json=deduplicationKey,proto3" json:"deduplication_key,omitempty"`
+	// Tiempo de vida de la tarea contado desde su ETA mínimo, o desde su creación
+	// si no tiene. Si está vacío la tarea no caduca.
+	Ttl *duration.Duration `protobuf:"bytes,11,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	// Etiquetas con las que se envió la tarea.
+	Tags                 []string `protobuf:"bytes,12,rep,name=tags,proto3" json:"tags,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -432,6 +486,41 @@ func (m *Task) GetQueueName() string {
 	return ""
 }
 
+func (m *Task) GetHeaders() map[string]string {
+	if m != nil {
+		return m.Headers
+	}
+	return nil
+}
+
+func (m *Task) GetPriority() int32 {
+	if m != nil {
+		return m.Priority
+	}
+	return 0
+}
+
+func (m *Task) GetDeduplicationKey() string {
+	if m != nil {
+		return m.DeduplicationKey
+	}
+	return ""
+}
+
+func (m *Task) GetTtl() *duration.Duration {
+	if m != nil {
+		return m.Ttl
+	}
+	return nil
+}
+
+func (m *Task) GetTags() []string {
+	if m != nil {
+		return m.Tags
+	}
+	return nil
+}
+
 type SendTasksRequest struct {
 	// Código de proyecto.
 	Project string `protobuf:"bytes,1,opt,name=project,proto3" json:"project,omitempty"`
@@ -496,10 +585,29 @@ type SendTask struct {
 	// ETA (Estimated Time of Arrival) mínimo que debe tener la tarea. Nos permite
 	// retrasar una tarea si le pasamos una fecha futura. Si no está especificada
 	// o es una fecha pasada la tarea se ejecutará lo antes posible.
-	MinEta               *timestamp.Timestamp `protobuf:"bytes,2,opt,name=min_eta,json=minEta,proto3" json:"min_eta,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}             `json:"-"`
-	XXX_unrecognized     []byte               `json:"-"`
-	XXX_sizecache        int32                `json:"-"`
+	MinEta *timestamp.Timestamp `protobuf:"bytes,2,opt,name=min_eta,json=minEta,proto3" json:"min_eta,omitempty"`
+	// Número de reintento de la ejecución. Permite a los clientes que gestionan sus
+	// propios reintentos volver a encolar una tarea conservando el contador.
+	Retry int32 `protobuf:"varint,3,opt,name=retry,proto3" json:"retry,omitempty"`
+	// Cabeceras con metadatos de la tarea que se entregarán junto al contenido.
+	Headers map[string]string `protobuf:"bytes,4,rep,name=headers,proto3" json:"headers,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// Prioridad de la tarea. Las tareas con mayor valor se ejecutan antes; por
+	// defecto todas tienen prioridad 0.
+	Priority int32 `protobuf:"varint,5,opt,name=priority,proto3" json:"priority,omitempty"`
+	// Clave de deduplicación de la tarea. El servidor descartará las tareas que
+	// lleguen con la misma clave que otra anterior dentro de la ventana de
+	// deduplicación de la cola.
+	DeduplicationKey string `protobuf:"bytes,6,opt,name=deduplication_key,json=deduplicationKey,proto3" json:"deduplication_key,omitempty"`
+	// Tiempo de vida de la tarea contado desde su ETA mínimo, o desde el momento
+	// en el que se envía si no tiene. El servidor puede descartar las tareas
+	// caducadas que no se hayan entregado todavía.
+	Ttl *duration.Duration `protobuf:"bytes,7,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	// Etiquetas de la tarea con los requisitos de los trabajadores que pueden
+	// ejecutarla.
+	Tags                 []string `protobuf:"bytes,8,rep,name=tags,proto3" json:"tags,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *SendTask) Reset()         { *m = SendTask{} }
@@ -541,6 +649,48 @@ func (m *SendTask) GetMinEta() *timestamp.Timestamp {
 	return nil
 }
 
+func (m *SendTask) GetRetry() int32 {
+	if m != nil {
+		return m.Retry
+	}
+	return 0
+}
+
+func (m *SendTask) GetHeaders() map[string]string {
+	if m != nil {
+		return m.Headers
+	}
+	return nil
+}
+
+func (m *SendTask) GetPriority() int32 {
+	if m != nil {
+		return m.Priority
+	}
+	return 0
+}
+
+func (m *SendTask) GetDeduplicationKey() string {
+	if m != nil {
+		return m.DeduplicationKey
+	}
+	return ""
+}
+
+func (m *SendTask) GetTtl() *duration.Duration {
+	if m != nil {
+		return m.Ttl
+	}
+	return nil
+}
+
+func (m *SendTask) GetTags() []string {
+	if m != nil {
+		return m.Tags
+	}
+	return nil
+}
+
 type SendTasksReply struct {
 	// Listado de códigos de tareas que se han creado en el servidor.
 	Codes                []string `protobuf:"bytes,1,rep,name=codes,proto3" json:"codes,omitempty"`
@@ -727,10 +877,20 @@ type Queue struct {
 	//   1m, 2m, 4m, 8m, 16m, 32m, 1h4m, 2h8m, 4h16m, 8h32m.
 	MaxRetries int32 `protobuf:"varint,6,opt,name=max_retries,json=maxRetries,proto3" json:"max_retries,omitempty"`
 	// Tareas máximas que se estarán ejecutando al mismo tiempo.
-	MaxConcurrent        int32    `protobuf:"varint,7,opt,name=max_concurrent,json=maxConcurrent,proto3" json:"max_concurrent,omitempty"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+	MaxConcurrent int32 `protobuf:"varint,7,opt,name=max_concurrent,json=maxConcurrent,proto3" json:"max_concurrent,omitempty"`
+	// Segundos durante los que se recuerdan las claves de deduplicación de las
+	// tareas enviadas a la cola.
+	DeduplicationWindow int32 `protobuf:"varint,8,opt,name=deduplication_window,json=deduplicationWindow,proto3" json:"deduplication_window,omitempty"`
+	// Número de tareas pendientes en la cola.
+	Depth int64 `protobuf:"varint,9,opt,name=depth,proto3" json:"depth,omitempty"`
+	// Fecha de creación de la cola.
+	Created *timestamp.Timestamp `protobuf:"bytes,10,opt,name=created,proto3" json:"created,omitempty"`
+	// Estado de la cola. Las colas que se están vaciando no aceptan tareas nuevas
+	// pero siguen ejecutando las pendientes.
+	Status               Queue_Status `protobuf:"varint,11,opt,name=status,proto3,enum=queues.queues.Queue_Status" json:"status,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}     `json:"-"`
+	XXX_unrecognized     []byte       `json:"-"`
+	XXX_sizecache        int32        `json:"-"`
 }
 
 func (m *Queue) Reset()         { *m = Queue{} }
@@ -807,6 +967,34 @@ func (m *Queue) GetMaxConcurrent() int32 {
 	return 0
 }
 
+func (m *Queue) GetDeduplicationWindow() int32 {
+	if m != nil {
+		return m.DeduplicationWindow
+	}
+	return 0
+}
+
+func (m *Queue) GetDepth() int64 {
+	if m != nil {
+		return m.Depth
+	}
+	return 0
+}
+
+func (m *Queue) GetCreated() *timestamp.Timestamp {
+	if m != nil {
+		return m.Created
+	}
+	return nil
+}
+
+func (m *Queue) GetStatus() Queue_Status {
+	if m != nil {
+		return m.Status
+	}
+	return Queue_STATUS_UNKNOWN
+}
+
 type ListReply struct {
 	Queues               []*Queue `protobuf:"bytes,2,rep,name=queues,proto3" json:"queues,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -944,15 +1132,117 @@ func (m *ResumeRequest) GetQueueName() string {
 	return ""
 }
 
+type PurgeRequest struct {
+	// Código de proyecto.
+	Project string `protobuf:"bytes,1,opt,name=project,proto3" json:"project,omitempty"`
+	// Nombre de la cola.
+	QueueName string `protobuf:"bytes,2,opt,name=queue_name,json=queueName,proto3" json:"queue_name,omitempty"`
+	// Confirmación explícita de la operación. El servidor la exige para purgar
+	// las colas de los proyectos de producción.
+	Confirm              bool     `protobuf:"varint,3,opt,name=confirm,proto3" json:"confirm,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PurgeRequest) Reset()         { *m = PurgeRequest{} }
+func (m *PurgeRequest) String() string { return proto.CompactTextString(m) }
+func (*PurgeRequest) ProtoMessage()    {}
+func (*PurgeRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_05add8dac95ef17c, []int{15}
+}
+
+func (m *PurgeRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PurgeRequest.Unmarshal(m, b)
+}
+func (m *PurgeRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PurgeRequest.Marshal(b, m, deterministic)
+}
+func (m *PurgeRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PurgeRequest.Merge(m, src)
+}
+func (m *PurgeRequest) XXX_Size() int {
+	return xxx_messageInfo_PurgeRequest.Size(m)
+}
+func (m *PurgeRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PurgeRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PurgeRequest proto.InternalMessageInfo
+
+func (m *PurgeRequest) GetProject() string {
+	if m != nil {
+		return m.Project
+	}
+	return ""
+}
+
+func (m *PurgeRequest) GetQueueName() string {
+	if m != nil {
+		return m.QueueName
+	}
+	return ""
+}
+
+func (m *PurgeRequest) GetConfirm() bool {
+	if m != nil {
+		return m.Confirm
+	}
+	return false
+}
+
+type PurgeReply struct {
+	// Número de tareas eliminadas.
+	Purged               int64    `protobuf:"varint,1,opt,name=purged,proto3" json:"purged,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PurgeReply) Reset()         { *m = PurgeReply{} }
+func (m *PurgeReply) String() string { return proto.CompactTextString(m) }
+func (*PurgeReply) ProtoMessage()    {}
+func (*PurgeReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_05add8dac95ef17c, []int{16}
+}
+
+func (m *PurgeReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PurgeReply.Unmarshal(m, b)
+}
+func (m *PurgeReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PurgeReply.Marshal(b, m, deterministic)
+}
+func (m *PurgeReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PurgeReply.Merge(m, src)
+}
+func (m *PurgeReply) XXX_Size() int {
+	return xxx_messageInfo_PurgeReply.Size(m)
+}
+func (m *PurgeReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_PurgeReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PurgeReply proto.InternalMessageInfo
+
+func (m *PurgeReply) GetPurged() int64 {
+	if m != nil {
+		return m.Purged
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterEnum("queues.queues.Queue_Unit", Queue_Unit_name, Queue_Unit_value)
+	proto.RegisterEnum("queues.queues.Queue_Status", Queue_Status_name, Queue_Status_value)
 	proto.RegisterType((*ListenRequest)(nil), "queues.queues.ListenRequest")
 	proto.RegisterType((*ListenInitial)(nil), "queues.queues.ListenInitial")
 	proto.RegisterType((*Ack)(nil), "queues.queues.Ack")
 	proto.RegisterType((*ListenReply)(nil), "queues.queues.ListenReply")
 	proto.RegisterType((*Task)(nil), "queues.queues.Task")
+	proto.RegisterMapType((map[string]string)(nil), "queues.queues.Task.HeadersEntry")
 	proto.RegisterType((*SendTasksRequest)(nil), "queues.queues.SendTasksRequest")
 	proto.RegisterType((*SendTask)(nil), "queues.queues.SendTask")
+	proto.RegisterMapType((map[string]string)(nil), "queues.queues.SendTask.HeadersEntry")
 	proto.RegisterType((*SendTasksReply)(nil), "queues.queues.SendTasksReply")
 	proto.RegisterType((*ListTasksRequest)(nil), "queues.queues.ListTasksRequest")
 	proto.RegisterType((*ListTasksReply)(nil), "queues.queues.ListTasksReply")
@@ -961,6 +1251,8 @@ func init() {
 	proto.RegisterType((*ListReply)(nil), "queues.queues.ListReply")
 	proto.RegisterType((*PauseRequest)(nil), "queues.queues.PauseRequest")
 	proto.RegisterType((*ResumeRequest)(nil), "queues.queues.ResumeRequest")
+	proto.RegisterType((*PurgeRequest)(nil), "queues.queues.PurgeRequest")
+	proto.RegisterType((*PurgeReply)(nil), "queues.queues.PurgeReply")
 }
 
 func init() {
@@ -968,62 +1260,83 @@ func init() {
 }
 
 var fileDescriptor_05add8dac95ef17c = []byte{
-	// 867 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x55, 0xdd, 0x6e, 0x1b, 0x45,
-	0x14, 0xce, 0xda, 0x5e, 0x3b, 0x3e, 0x8e, 0x2d, 0x6b, 0x88, 0x60, 0x31, 0xa9, 0x12, 0x8d, 0x20,
-	0x71, 0x2b, 0x62, 0x23, 0x07, 0xa1, 0xb6, 0x70, 0x53, 0x4a, 0x84, 0xa3, 0x82, 0x5b, 0xc6, 0x89,
-	0x10, 0x57, 0x66, 0xba, 0x1e, 0xaa, 0xc5, 0xde, 0x9f, 0xee, 0xcc, 0x42, 0x4c, 0xdb, 0x1b, 0x24,
-	0x9e, 0x80, 0x97, 0xe0, 0x92, 0x77, 0xe1, 0x15, 0xb8, 0xe6, 0x19, 0xd0, 0x9c, 0xd9, 0x31, 0xb6,
-	0x6b, 0xb7, 0x81, 0x70, 0xe5, 0x3d, 0x67, 0xbf, 0x39, 0xdf, 0x39, 0xdf, 0xf9, 0x66, 0x0d, 0x87,
-	0x3c, 0x49, 0x64, 0xf7, 0x69, 0x26, 0x32, 0x21, 0xbb, 0x49, 0x1a, 0xab, 0x78, 0x1e, 0x99, 0x9f,
-	0x0e, 0x26, 0x49, 0x3d, 0x8f, 0xcc, 0x4f, 0x6b, 0xff, 0x49, 0x1c, 0x3f, 0x99, 0x0a, 0x73, 0xe2,
-	0x71, 0xf6, 0x5d, 0x57, 0x05, 0xa1, 0x90, 0x8a, 0x87, 0x89, 0xc1, 0xb7, 0xf6, 0x72, 0x00, 0x4f,
-	0x82, 0x2e, 0x8f, 0xa2, 0x58, 0x71, 0x15, 0xc4, 0x51, 0x5e, 0x8d, 0x3e, 0x87, 0xfa, 0x17, 0x81,
-	0x54, 0x22, 0x62, 0xe2, 0x69, 0x26, 0xa4, 0x22, 0xb7, 0xa1, 0x12, 0x44, 0x81, 0x0a, 0xf8, 0xd4,
-	0x73, 0x0e, 0x9c, 0x76, 0xad, 0xb7, 0xd7, 0x59, 0x22, 0xec, 0x18, 0xf8, 0x99, 0xc1, 0xf4, 0xb7,
-	0x98, 0x85, 0x93, 0x43, 0x28, 0x72, 0x7f, 0xe2, 0x15, 0xf0, 0x14, 0x59, 0x39, 0x75, 0xcf, 0x9f,
-	0xf4, 0xb7, 0x98, 0x06, 0x7c, 0x5a, 0x85, 0x4a, 0x6a, 0xc8, 0x68, 0xdf, 0xb2, 0xe7, 0xe5, 0x88,
-	0x07, 0x95, 0x24, 0x8d, 0xbf, 0x17, 0xbe, 0x42, 0xf6, 0x2a, 0xb3, 0x21, 0xb9, 0x01, 0x80, 0xa5,
-	0x46, 0x11, 0x0f, 0x05, 0x92, 0x54, 0x59, 0x15, 0x33, 0x03, 0x1e, 0x0a, 0x7a, 0x02, 0xc5, 0x7b,
-	0xfe, 0x84, 0x10, 0x28, 0xf9, 0xf1, 0x58, 0x78, 0x45, 0x7c, 0x8f, 0xcf, 0xba, 0xa6, 0xcc, 0x7c,
-	0x5f, 0x48, 0xe9, 0x95, 0x0e, 0x9c, 0xf6, 0x36, 0xb3, 0x21, 0xfd, 0x08, 0x6a, 0x76, 0xf8, 0x64,
-	0x3a, 0x23, 0x47, 0x50, 0x52, 0x5c, 0x4e, 0xf2, 0xb9, 0xdf, 0x58, 0x99, 0xe0, 0x9c, 0xcb, 0x09,
-	0x43, 0x00, 0xfd, 0xcb, 0x81, 0x92, 0x0e, 0xe7, 0x74, 0xce, 0x32, 0x5d, 0xc2, 0x67, 0xd3, 0x98,
-	0x8f, 0xb1, 0xcb, 0x1d, 0x66, 0x43, 0xf2, 0x21, 0x54, 0xfc, 0x54, 0x70, 0x25, 0xc6, 0xd8, 0x5f,
-	0xad, 0xd7, 0xea, 0x98, 0xdd, 0x74, 0xec, 0xf2, 0x3a, 0xe7, 0x76, 0x79, 0xcc, 0x42, 0xc9, 0x2e,
-	0xb8, 0xa9, 0x50, 0xe9, 0x0c, 0x9b, 0x77, 0x99, 0x09, 0xc8, 0x09, 0x54, 0xc2, 0x20, 0x1a, 0x09,
-	0xc5, 0x3d, 0xf7, 0xb5, 0xb5, 0xca, 0x61, 0x10, 0x9d, 0x2a, 0xbe, 0xa8, 0x6e, 0xf9, 0x55, 0xea,
-	0x56, 0x56, 0xd5, 0xfd, 0x09, 0x9a, 0x43, 0x11, 0x8d, 0xf5, 0xcc, 0xd2, 0x1a, 0xe5, 0xbf, 0xae,
-	0x8a, 0x1c, 0x83, 0xab, 0x55, 0x94, 0x5e, 0xf1, 0xa0, 0xd8, 0xae, 0xf5, 0xde, 0x5a, 0xd1, 0xd9,
-	0x12, 0x31, 0x83, 0xa2, 0xdf, 0xc0, 0xb6, 0x4d, 0x2d, 0x6a, 0xeb, 0x2c, 0x6b, 0xbb, 0xa0, 0x47,
-	0xe1, 0xaa, 0x7a, 0xd0, 0x43, 0x68, 0x2c, 0x8c, 0xa5, 0x2d, 0xb0, 0x0b, 0xae, 0x5e, 0xa2, 0xf4,
-	0x9c, 0x83, 0x62, 0xbb, 0xca, 0x4c, 0x40, 0x1f, 0x40, 0x53, 0xfb, 0xe4, 0x7f, 0x19, 0x9f, 0x7e,
-	0x0c, 0x8d, 0x85, 0x62, 0x9a, 0xf4, 0xa6, 0x15, 0xc4, 0x41, 0x41, 0xd6, 0x1a, 0x2f, 0x17, 0xe3,
-	0xc8, 0x38, 0xf6, 0xb5, 0x4d, 0xd0, 0xdf, 0x0a, 0xe0, 0x7e, 0xa5, 0xcf, 0xbf, 0xa2, 0x51, 0x02,
-	0xa5, 0x85, 0x16, 0xf1, 0x99, 0xbc, 0x0b, 0x0d, 0x64, 0x1a, 0x25, 0x22, 0x1d, 0x65, 0x51, 0xa0,
-	0xd0, 0xaa, 0x45, 0xb6, 0x83, 0xd9, 0x47, 0x22, 0xbd, 0x88, 0x02, 0x45, 0x8e, 0xa1, 0x84, 0xef,
-	0xb4, 0x25, 0x1b, 0xbd, 0xb7, 0x57, 0x1a, 0x46, 0xde, 0x8e, 0x06, 0x32, 0x84, 0x91, 0x37, 0xa1,
-	0x9c, 0xf0, 0x4c, 0x8a, 0x31, 0x7a, 0x75, 0x9b, 0xe5, 0x11, 0xd9, 0x87, 0x5a, 0xc8, 0x2f, 0x47,
-	0xda, 0xd1, 0x81, 0x90, 0xe8, 0x49, 0x97, 0x41, 0xc8, 0x2f, 0x99, 0xc9, 0x90, 0xf7, 0xa0, 0xa1,
-	0x01, 0x7e, 0x1c, 0xf9, 0x59, 0x9a, 0x8a, 0x48, 0xa1, 0x35, 0x5d, 0x56, 0x0f, 0xf9, 0xe5, 0xfd,
-	0x79, 0x92, 0x7e, 0x02, 0x25, 0x6c, 0xab, 0x09, 0x3b, 0x17, 0x83, 0xb3, 0xf3, 0xd1, 0xc5, 0xe0,
-	0xc1, 0xe0, 0xe1, 0xd7, 0x83, 0xe6, 0xd6, 0x3c, 0x33, 0x3c, 0xbd, 0xff, 0x70, 0xf0, 0xd9, 0xb0,
-	0xe9, 0xcc, 0x33, 0x5f, 0x9e, 0x0d, 0x2e, 0xce, 0x4f, 0x87, 0xcd, 0x02, 0xbd, 0x03, 0x55, 0xa3,
-	0xa9, 0xde, 0xc5, 0xfb, 0x50, 0x36, 0x53, 0x78, 0x05, 0x5c, 0xc6, 0xee, 0xba, 0xd9, 0x58, 0x8e,
-	0xa1, 0x9f, 0xc3, 0xce, 0x23, 0x3d, 0xca, 0xb5, 0x4d, 0xd1, 0x87, 0x3a, 0x13, 0x32, 0x0b, 0xaf,
-	0x5d, 0xa9, 0xf7, 0xbb, 0x0b, 0x75, 0x6c, 0x52, 0x0e, 0x45, 0xfa, 0x43, 0xe0, 0x0b, 0xd2, 0x87,
-	0xb2, 0xf9, 0xca, 0x91, 0xf5, 0x9f, 0xf2, 0x9c, 0xb2, 0xd5, 0xda, 0xf0, 0x36, 0x99, 0xce, 0xe8,
-	0x56, 0xdb, 0xf9, 0xc0, 0x21, 0xbf, 0x38, 0x50, 0x9d, 0x5f, 0x18, 0xb2, 0xbf, 0xe1, 0xe2, 0xda,
-	0x2b, 0xd2, 0xba, 0xb1, 0x19, 0xa0, 0x6b, 0xde, 0xfe, 0xf9, 0x8f, 0x3f, 0x7f, 0x2d, 0xf4, 0xe8,
-	0x71, 0x37, 0x1f, 0x4d, 0x76, 0x9f, 0xe5, 0x4f, 0x2f, 0xec, 0x3f, 0xdf, 0xb3, 0x7f, 0x46, 0x7d,
-	0xd1, 0x45, 0x0b, 0xde, 0x75, 0x6e, 0x91, 0xe7, 0x66, 0x63, 0xeb, 0xdb, 0x58, 0xbd, 0xa9, 0x2f,
-	0xb5, 0xb1, 0x7c, 0xfb, 0x68, 0x17, 0xdb, 0xb8, 0x49, 0x8e, 0xae, 0xd8, 0x06, 0xf9, 0x16, 0x4a,
-	0xba, 0x04, 0x59, 0xa7, 0x97, 0xe5, 0xf4, 0xd6, 0xbe, 0xd3, 0x74, 0x14, 0xe9, 0xf6, 0x48, 0x6b,
-	0x33, 0x1d, 0x51, 0xe0, 0xa2, 0xad, 0xc8, 0x3b, 0x2b, 0x65, 0x16, 0xcd, 0xd6, 0x5a, 0x6b, 0xcd,
-	0x7f, 0xaf, 0x2a, 0xde, 0x45, 0xad, 0xea, 0x8f, 0x50, 0x36, 0x1e, 0x7c, 0xc9, 0x27, 0x4b, 0xd6,
-	0xdc, 0xc0, 0x7b, 0x07, 0x79, 0x4f, 0x68, 0xe7, 0xaa, 0xbc, 0x29, 0x16, 0xbd, 0xeb, 0xdc, 0x7a,
-	0x5c, 0xc6, 0x4f, 0xf4, 0xc9, 0xdf, 0x01, 0x00, 0x00, 0xff, 0xff, 0xea, 0x1b, 0x96, 0x67, 0x02,
-	0x09, 0x00, 0x00,
+	// 1209 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x56, 0xdf, 0x72, 0xd3, 0xc6,
+	0x17, 0x8e, 0x2c, 0xff, 0x3d, 0xb1, 0xfd, 0x13, 0x4b, 0xe6, 0x57, 0xa1, 0x42, 0xc9, 0x68, 0x28,
+	0x04, 0x5a, 0xec, 0xd6, 0xe9, 0x74, 0x20, 0xed, 0x74, 0x26, 0x25, 0x19, 0x92, 0xa1, 0x35, 0xe9,
+	0xda, 0x29, 0x97, 0xee, 0x22, 0x2d, 0x41, 0xb5, 0x2d, 0x09, 0x69, 0x05, 0xb8, 0xc0, 0x4d, 0x67,
+	0xfa, 0x04, 0x7d, 0x8c, 0x5e, 0xf7, 0x49, 0xfa, 0x0a, 0x7d, 0x80, 0xde, 0xf4, 0xbe, 0xb3, 0x67,
+	0x25, 0x23, 0x1b, 0x1b, 0x0c, 0xe1, 0x4a, 0x7b, 0xce, 0x7e, 0x7b, 0xbe, 0xb3, 0xe7, 0x7c, 0x7b,
+	0x46, 0x70, 0x99, 0x85, 0x61, 0xdc, 0x7e, 0x94, 0xf0, 0x84, 0xc7, 0xed, 0x30, 0x0a, 0x44, 0x30,
+	0xb5, 0xd4, 0xa7, 0x85, 0x4e, 0xd2, 0x48, 0x2d, 0xf5, 0xb1, 0x3e, 0x3a, 0x09, 0x82, 0x93, 0x11,
+	0x57, 0x27, 0xee, 0x27, 0x0f, 0xda, 0x6e, 0x12, 0x31, 0xe1, 0x05, 0xbe, 0x82, 0x5b, 0x17, 0xe7,
+	0xf7, 0x85, 0x37, 0xe6, 0xb1, 0x60, 0xe3, 0x30, 0x05, 0x9c, 0x4f, 0x01, 0x2c, 0xf4, 0xda, 0xcc,
+	0xf7, 0x03, 0x81, 0xa7, 0x53, 0x36, 0xfb, 0x39, 0x34, 0xbe, 0xf3, 0x62, 0xc1, 0x7d, 0xca, 0x1f,
+	0x25, 0x3c, 0x16, 0xe4, 0x06, 0x54, 0x3c, 0xdf, 0x13, 0x1e, 0x1b, 0x99, 0xda, 0xa6, 0xb6, 0xb5,
+	0xde, 0x39, 0xdf, 0x9a, 0x49, 0xa8, 0xa5, 0xe0, 0x87, 0x0a, 0x73, 0xb0, 0x46, 0x33, 0x38, 0xb9,
+	0x0c, 0x3a, 0x73, 0x86, 0x66, 0x01, 0x4f, 0x91, 0xb9, 0x53, 0xbb, 0xce, 0xf0, 0x60, 0x8d, 0x4a,
+	0xc0, 0xb7, 0x35, 0xa8, 0x44, 0x8a, 0xcc, 0x4e, 0xa0, 0x31, 0x13, 0x8e, 0x98, 0x50, 0x09, 0xa3,
+	0xe0, 0x67, 0xee, 0x08, 0x64, 0xaf, 0xd1, 0xcc, 0x24, 0x17, 0x00, 0x30, 0xd4, 0xc0, 0x67, 0x63,
+	0x8e, 0x24, 0x35, 0x5a, 0x43, 0x4f, 0x97, 0x8d, 0x39, 0xf9, 0x04, 0xce, 0x84, 0x91, 0x17, 0x44,
+	0x9e, 0x98, 0x0c, 0x82, 0xc8, 0xe5, 0x91, 0xe7, 0x9f, 0x98, 0xfa, 0xa6, 0xb6, 0x55, 0xa5, 0x46,
+	0xb6, 0x71, 0x37, 0xf5, 0xdb, 0xdb, 0xa0, 0xef, 0x3a, 0x43, 0x42, 0xa0, 0xe8, 0x04, 0x2e, 0x47,
+	0x58, 0x8d, 0xe2, 0x5a, 0x26, 0x10, 0x27, 0x8e, 0xc3, 0xe3, 0xd8, 0x2c, 0xe2, 0xe9, 0xcc, 0xb4,
+	0xbf, 0x84, 0xf5, 0xac, 0x52, 0xe1, 0x68, 0x42, 0xae, 0x40, 0x51, 0xb0, 0x78, 0x98, 0x16, 0xe9,
+	0xec, 0xdc, 0x75, 0xfb, 0x2c, 0x1e, 0x52, 0x04, 0xd8, 0xff, 0xe8, 0x50, 0x94, 0xe6, 0x94, 0x4e,
+	0x9b, 0xa5, 0x0b, 0xd9, 0x64, 0x14, 0x30, 0x17, 0xaf, 0x54, 0xa7, 0x99, 0x49, 0xbe, 0x80, 0x8a,
+	0x13, 0x71, 0x26, 0xb8, 0x8b, 0xf9, 0xad, 0x77, 0xac, 0x96, 0x6a, 0x64, 0x2b, 0xeb, 0x74, 0xab,
+	0x9f, 0x75, 0x9a, 0x66, 0x50, 0xb2, 0x01, 0xa5, 0x88, 0x8b, 0x68, 0x82, 0xc9, 0x97, 0xa8, 0x32,
+	0xc8, 0x36, 0x54, 0xc6, 0x9e, 0x3f, 0xe0, 0x82, 0x99, 0xa5, 0x37, 0xc6, 0x2a, 0x8f, 0x3d, 0x7f,
+	0x5f, 0xb0, 0x7c, 0x2b, 0xca, 0xaf, 0x6b, 0x45, 0x65, 0xbe, 0x15, 0x3b, 0x50, 0x79, 0xc8, 0x99,
+	0xcb, 0xa3, 0xd8, 0xac, 0x6e, 0xea, 0x5b, 0xeb, 0x9d, 0xcd, 0x05, 0xc5, 0x69, 0x1d, 0x28, 0xc8,
+	0xbe, 0x2f, 0xa2, 0x09, 0xcd, 0x0e, 0x10, 0x0b, 0xaa, 0x59, 0xb7, 0xcc, 0x1a, 0x5e, 0x61, 0x6a,
+	0xcb, 0x16, 0xbb, 0xdc, 0x4d, 0xc2, 0x91, 0xe7, 0xa0, 0x84, 0x07, 0x43, 0x3e, 0x31, 0x01, 0xd9,
+	0x8d, 0x99, 0x8d, 0x3b, 0x5c, 0x82, 0x75, 0x21, 0x46, 0xe6, 0x3a, 0x5e, 0xf7, 0xdc, 0x2b, 0xd7,
+	0xdd, 0x4b, 0x1f, 0x11, 0x95, 0x28, 0xd9, 0x19, 0xc1, 0x4e, 0x62, 0xb3, 0xbe, 0xa9, 0xcb, 0xce,
+	0xc8, 0xb5, 0xb5, 0x03, 0xf5, 0x7c, 0x8a, 0xc4, 0x00, 0x5d, 0xf2, 0xa9, 0xe6, 0xc9, 0xa5, 0xac,
+	0xf5, 0x63, 0x36, 0x4a, 0x32, 0x31, 0x2a, 0x63, 0xa7, 0x70, 0x43, 0xb3, 0x7f, 0x01, 0xa3, 0xc7,
+	0x7d, 0x57, 0xde, 0x33, 0xce, 0xde, 0xd5, 0x3b, 0x2b, 0xfb, 0x3a, 0x94, 0xa4, 0x8e, 0x62, 0x53,
+	0xc7, 0x62, 0x7e, 0x30, 0x57, 0xcc, 0x8c, 0x88, 0x2a, 0x94, 0xfd, 0x6f, 0x01, 0xaa, 0x99, 0x2f,
+	0x2f, 0x2f, 0x6d, 0x56, 0x5e, 0x39, 0x49, 0x14, 0x56, 0x96, 0xc4, 0x54, 0x5d, 0x7a, 0x5e, 0x5d,
+	0xdf, 0xbc, 0xec, 0x77, 0x11, 0x53, 0xbc, 0xb4, 0x24, 0xc5, 0x15, 0x7a, 0x5e, 0x5a, 0xa5, 0xe7,
+	0xe5, 0xd7, 0xf7, 0xbc, 0xf2, 0x56, 0x3d, 0xaf, 0xbe, 0xa7, 0x9e, 0x5f, 0x86, 0x66, 0xae, 0xe7,
+	0x72, 0x42, 0x6c, 0x40, 0x49, 0xbe, 0xf1, 0xd8, 0xd4, 0x90, 0x42, 0x19, 0xf6, 0x1d, 0x30, 0xe4,
+	0x18, 0x79, 0x2f, 0xda, 0xb0, 0xbf, 0x82, 0x66, 0x2e, 0x98, 0x24, 0xbd, 0x9a, 0xa9, 0x45, 0xdb,
+	0xd4, 0x97, 0xcd, 0xa5, 0x54, 0x29, 0x57, 0xd4, 0x40, 0x7b, 0x63, 0x12, 0xf6, 0x9f, 0x45, 0x28,
+	0xfd, 0x20, 0xcf, 0xbf, 0x26, 0x51, 0x02, 0xc5, 0x5c, 0x8a, 0xb8, 0x26, 0x97, 0xa0, 0x89, 0x4c,
+	0x83, 0x90, 0x47, 0x83, 0xc4, 0xf7, 0x04, 0xea, 0x46, 0xa7, 0x75, 0xf4, 0x1e, 0xf1, 0xe8, 0xd8,
+	0xf7, 0x04, 0xb9, 0x0e, 0x45, 0xdc, 0x93, 0x13, 0xab, 0xd9, 0x39, 0x37, 0x97, 0x30, 0xf2, 0xb6,
+	0x24, 0x90, 0x22, 0x8c, 0xfc, 0x1f, 0xca, 0x21, 0x4b, 0x62, 0xee, 0xa2, 0x56, 0xaa, 0x34, 0xb5,
+	0xc8, 0x45, 0x58, 0x1f, 0xb3, 0xa7, 0x03, 0x29, 0x49, 0x8f, 0xc7, 0xa8, 0x91, 0x12, 0x85, 0x31,
+	0x7b, 0x4a, 0x95, 0x87, 0x7c, 0x0c, 0x4d, 0x09, 0x70, 0x02, 0xdf, 0x49, 0xa2, 0x88, 0xfb, 0x02,
+	0x85, 0x52, 0xa2, 0x8d, 0x31, 0x7b, 0x7a, 0x6b, 0xea, 0x24, 0x9f, 0xc3, 0xc6, 0xac, 0xe2, 0x9e,
+	0x78, 0xbe, 0x1b, 0x3c, 0x31, 0xab, 0x08, 0x3e, 0x3b, 0xb3, 0x77, 0x0f, 0xb7, 0x64, 0xa3, 0x5d,
+	0x1e, 0x8a, 0x87, 0x38, 0xb1, 0x74, 0xaa, 0x8c, 0xfc, 0x00, 0x87, 0xd5, 0x07, 0xf8, 0x36, 0x94,
+	0x63, 0xc1, 0x44, 0x12, 0xe3, 0xe8, 0x6a, 0x76, 0x3e, 0x5c, 0x58, 0x8f, 0x1e, 0x42, 0x68, 0x0a,
+	0xb5, 0xbf, 0x86, 0x22, 0x96, 0xd2, 0x80, 0xfa, 0x71, 0xf7, 0xb0, 0x3f, 0x38, 0xee, 0xde, 0xe9,
+	0xde, 0xbd, 0xd7, 0x35, 0xd6, 0xa6, 0x9e, 0xde, 0xfe, 0xad, 0xbb, 0xdd, 0xbd, 0x9e, 0xa1, 0x4d,
+	0x3d, 0xdf, 0x1f, 0x76, 0x8f, 0xfb, 0xfb, 0x3d, 0xa3, 0x60, 0xdf, 0x83, 0xb2, 0x8a, 0x47, 0x08,
+	0x34, 0x7b, 0xfd, 0xdd, 0xfe, 0x71, 0x2f, 0x17, 0xe1, 0x0c, 0x34, 0x52, 0xdf, 0xee, 0xad, 0xfe,
+	0xe1, 0x8f, 0xfb, 0x86, 0x96, 0x73, 0x1d, 0xed, 0x1e, 0xf7, 0xf6, 0xf7, 0x8c, 0x02, 0x39, 0x0b,
+	0xff, 0x4b, 0x5d, 0x7b, 0x74, 0xf7, 0xb0, 0x7b, 0xd8, 0xbd, 0x6d, 0xe8, 0xf6, 0x4d, 0xa8, 0x29,
+	0x81, 0x49, 0x61, 0x7e, 0x0a, 0x65, 0x75, 0x05, 0xb3, 0x80, 0xca, 0xdc, 0x58, 0x74, 0x31, 0x9a,
+	0x62, 0xec, 0xdb, 0x50, 0x3f, 0x92, 0x7d, 0x3d, 0xf5, 0x0b, 0x39, 0x80, 0x06, 0xe5, 0x71, 0x32,
+	0x3e, 0x7d, 0x24, 0x06, 0xf5, 0xa3, 0x24, 0x3a, 0x39, 0x75, 0x20, 0x79, 0xd0, 0x09, 0xfc, 0x07,
+	0x5e, 0x34, 0x4e, 0x7f, 0x50, 0x32, 0xd3, 0xbe, 0x04, 0x90, 0x52, 0xc8, 0x8a, 0x49, 0xa5, 0x4b,
+	0x4b, 0xcd, 0x6e, 0x9d, 0xa6, 0x56, 0xe7, 0x8f, 0x32, 0x34, 0xb0, 0x5a, 0x71, 0x8f, 0x47, 0x8f,
+	0x3d, 0x87, 0x93, 0x03, 0x28, 0xab, 0x5f, 0x13, 0xb2, 0xf8, 0x67, 0x2d, 0x4d, 0xd9, 0xb2, 0x96,
+	0xec, 0x86, 0xa3, 0x89, 0xbd, 0xb6, 0xa5, 0x7d, 0xa6, 0x91, 0xdf, 0x34, 0xa8, 0x4d, 0xc7, 0x18,
+	0xb9, 0xb8, 0x64, 0x90, 0x67, 0x83, 0xcb, 0xba, 0xb0, 0x1c, 0x20, 0x63, 0xde, 0xf8, 0xf5, 0xaf,
+	0xbf, 0x7f, 0x2f, 0x74, 0xec, 0xeb, 0xed, 0xb4, 0x34, 0x71, 0xfb, 0x59, 0xba, 0x7a, 0x91, 0xfd,
+	0xfb, 0x3e, 0x7b, 0x59, 0xaa, 0x17, 0x6d, 0x1c, 0x0c, 0x3b, 0xda, 0x35, 0xf2, 0x5c, 0x49, 0x67,
+	0x71, 0x1a, 0xf3, 0xf3, 0xd3, 0xba, 0xb0, 0x1c, 0x20, 0xd3, 0x68, 0x63, 0x1a, 0x57, 0xc9, 0x95,
+	0x15, 0xd3, 0x20, 0x3f, 0x41, 0x51, 0x86, 0x20, 0x8b, 0xea, 0x95, 0x71, 0x9a, 0x0b, 0xf7, 0x24,
+	0x9d, 0x8d, 0x74, 0xe7, 0x89, 0xb5, 0x9c, 0x8e, 0x08, 0x28, 0xa1, 0xbe, 0xc9, 0xfc, 0xfb, 0xce,
+	0xab, 0xde, 0x5a, 0xf8, 0x46, 0xde, 0xbe, 0xaa, 0x38, 0x21, 0x65, 0x55, 0x9f, 0x40, 0x59, 0x3d,
+	0x86, 0x57, 0x74, 0x32, 0xf3, 0x46, 0x96, 0xf0, 0xde, 0x44, 0xde, 0x6d, 0xbb, 0xb5, 0x2a, 0x6f,
+	0x84, 0x41, 0x25, 0xf1, 0x04, 0x4a, 0x28, 0xec, 0x57, 0xaf, 0x9b, 0x7b, 0x51, 0xd6, 0xb9, 0xc5,
+	0x9b, 0xef, 0xa4, 0x24, 0x7c, 0x2b, 0x3b, 0xda, 0xb5, 0xfb, 0x65, 0x9c, 0xb6, 0xdb, 0xff, 0x0d,
+	0x00, 0x97, 0xfc, 0x44, 0x32, 0x7f, 0x0d, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -1054,6 +1367,9 @@ type QueuesServiceClient interface {
 	//
 	// TODO(ernesto): Cambiar la ruta a {queue_name}:resume cuando esté soportada.
 	Resume(ctx context.Context, in *ResumeRequest, opts ...grpc.CallOption) (*Queue, error)
+	// Elimina todas las tareas pendientes de una cola. Las tareas que se están
+	// ejecutando en ese momento no se ven afectadas.
+	Purge(ctx context.Context, in *PurgeRequest, opts ...grpc.CallOption) (*PurgeReply, error)
 }
 
 type queuesServiceClient struct {
@@ -1140,6 +1456,15 @@ func (c *queuesServiceClient) Resume(ctx context.Context, in *ResumeRequest, opt
 	return out, nil
 }
 
+func (c *queuesServiceClient) Purge(ctx context.Context, in *PurgeRequest, opts ...grpc.CallOption) (*PurgeReply, error) {
+	out := new(PurgeReply)
+	err := c.cc.Invoke(ctx, "/queues.queues.QueuesService/Purge", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // QueuesServiceServer is the server API for QueuesService service.
 type QueuesServiceServer interface {
 	// Abre una conexión permanente para recibir tareas.
@@ -1158,6 +1483,9 @@ type QueuesServiceServer interface {
 	//
 	// TODO(ernesto): Cambiar la ruta a {queue_name}:resume cuando esté soportada.
 	Resume(context.Context, *ResumeRequest) (*Queue, error)
+	// Elimina todas las tareas pendientes de una cola. Las tareas que se están
+	// ejecutando en ese momento no se ven afectadas.
+	Purge(context.Context, *PurgeRequest) (*PurgeReply, error)
 }
 
 func RegisterQueuesServiceServer(s *grpc.Server, srv QueuesServiceServer) {
@@ -1280,6 +1608,24 @@ func _QueuesService_Resume_Handler(srv interface{}, ctx context.Context, dec fun
 	return interceptor(ctx, in, info, handler)
 }
 
+func _QueuesService_Purge_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PurgeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(QueuesServiceServer).Purge(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/queues.queues.QueuesService/Purge",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(QueuesServiceServer).Purge(ctx, req.(*PurgeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _QueuesService_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "queues.queues.QueuesService",
 	HandlerType: (*QueuesServiceServer)(nil),
@@ -1304,6 +1650,10 @@ var _QueuesService_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Resume",
 			Handler:    _QueuesService_Resume_Handler,
 		},
+		{
+			MethodName: "Purge",
+			Handler:    _QueuesService_Purge_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -0,0 +1,96 @@
+package delay
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/altipla-consulting/datetime"
+	"github.com/go-redis/redis"
+	log "github.com/sirupsen/logrus"
+
+	pb "github.com/altipla-consulting/delay/queues"
+)
+
+// Counts the executions of the window and returns the milliseconds until the
+// window finishes if the limit was exceeded, or zero otherwise.
+var rateLimitScript = redis.NewScript(`
+local n = redis.call("INCR", KEYS[1])
+if n == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+if n > tonumber(ARGV[1]) then
+	local ttl = redis.call("PTTL", KEYS[1])
+	if ttl < 1 then
+		ttl = 1
+	end
+	return ttl
+end
+return 0
+`)
+
+type distributedLimiter struct {
+	client *redis.Client
+	limit  int64
+	window time.Duration
+}
+
+// WithDistributedRateLimit limits the executions of the function to rps tasks per
+// second between all the instances of the application, counting them in Redis.
+// Tasks over the limit are sent again to the queue to run when the current
+// second finishes. Keys are prefixed with "delay-ratelimit:".
+func WithDistributedRateLimit(rps float64, client *redis.Client) FuncOption {
+	return func(f *Function) {
+		limiter := &distributedLimiter{
+			client: client,
+			limit:  int64(math.Floor(rps)),
+			window: time.Second,
+		}
+		// Rates under one task per second allow a single task in longer windows.
+		if rps < 1 {
+			limiter.limit = 1
+			limiter.window = time.Duration(float64(time.Second) / rps)
+		}
+		f.distLimiter = limiter
+	}
+}
+
+// wait returns how much time the task should wait before running, or zero if it
+// can run now.
+func (limiter *distributedLimiter) wait(ctx context.Context, key string) (time.Duration, error) {
+	client := limiter.client.WithContext(ctx)
+	ms, err := rateLimitScript.Run(client, []string{"delay-ratelimit:" + key}, limiter.limit, limiter.window.Milliseconds()).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("delay: cannot check rate limit: %w", err)
+	}
+
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// throttleTask sends again the task to the queue to run it after the wait. The
+// task is not counted as a retry.
+func (lis *Listener) throttleTask(ctx context.Context, queue QueueSpec, task *pb.Task, wait time.Duration) error {
+	eta := time.Now().Add(wait)
+	throttled := &pb.SendTask{
+		Payload:  task.Payload,
+		MinEta:   datetime.SerializeTimestamp(eta),
+		Retry:    task.Retry,
+		Headers:  task.Headers,
+		Priority: task.Priority,
+		Ttl:      retryTTL(task, eta),
+		Tags:     task.Tags,
+	}
+	if err := queue.SendTasks(ctx, []*pb.SendTask{throttled}); err != nil {
+		return fmt.Errorf("delay: cannot send throttled task: %w", err)
+	}
+
+	lis.logger.WithFields(log.Fields{
+		"project": task.Project,
+		"queue":   task.QueueName,
+		"task":    task.Code,
+		"wait":    wait.String(),
+	}).Debug("Task throttled by the distributed rate limit")
+
+	return nil
+}
@@ -0,0 +1,522 @@
+package delay
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/altipla-consulting/datetime"
+	"github.com/go-redis/redis"
+	"github.com/golang/protobuf/proto"
+	log "github.com/sirupsen/logrus"
+
+	pb "github.com/altipla-consulting/delay/queues"
+)
+
+const (
+	// Time a received task can stay without ack before other consumer claims it.
+	streamVisibilityTimeout = 5 * time.Minute
+
+	// Deliveries of a task before sending it to the stream of the queue with the
+	// suffix ":dlq".
+	streamMaxDeliveries = 10
+
+	// Maximum tasks read or claimed at the same time by each consumer if the queue
+	// is not handled with WithPrefetchCount.
+	streamBatchSize = 10
+
+	// Suffix of the sorted set of each queue where the tasks wait until their ETA.
+	streamDelayedSuffix = ":delayed"
+)
+
+// RedisOption configures the Redis connections.
+type RedisOption func(conn *Conn)
+
+// WithKeyPrefix prepends the prefix to the names of the streams, channels and lists
+// of the queues in Redis. For example with the prefix "staging:" the queue "emails"
+// uses the key "staging:emails", so multiple environments can share the same
+// Redis instance without mixing their tasks.
+func WithKeyPrefix(prefix string) RedisOption {
+	return func(conn *Conn) {
+		conn.redisPrefix = prefix
+	}
+}
+
+// WithConsumerGroup changes the name of the consumer group of the streams created
+// with NewConnRedisSentinel and NewConnRedisCluster. By default it is "delay".
+func WithConsumerGroup(name string) RedisOption {
+	return func(conn *Conn) {
+		if conn.redisStream != nil {
+			conn.redisStream.group = name
+		}
+	}
+}
+
+// WithRedisProject changes the project of the tasks received from the connections
+// created with NewConnRedisSentinel and NewConnRedisCluster.
+func WithRedisProject(project string) RedisOption {
+	return func(conn *Conn) {
+		conn.project = project
+	}
+}
+
+// redisKey returns the name of the queue in Redis.
+func (queue QueueSpec) redisKey() string {
+	return queue.conn.redisPrefix + queue.name
+}
+
+type redisStream struct {
+	client   redis.UniversalClient
+	group    string
+	consumer string
+
+	// The client is closed with the connection only if it was created by us.
+	ownClient bool
+}
+
+// NewConnRedisStream creates a connection that stores the tasks in Redis Streams.
+// Each queue is a stream with the same name and all the listeners of the group
+// share its tasks. Tasks are acknowledged when they finish and the failed ones
+// are claimed again by the group after a visibility timeout of 5 minutes.
+//
+// Tasks with an ETA in the future wait in a sorted set with the suffix ":delayed"
+// until the listeners move them to the stream, checking it every few seconds.
+//
+// The client can be any of the clients of go-redis, including the Sentinel and
+// Cluster ones.
+func NewConnRedisStream(client redis.UniversalClient, project, groupName string, opts ...RedisOption) (*Conn, error) {
+	if groupName == "" {
+		return nil, fmt.Errorf("delay: consumer group name required")
+	}
+
+	return newConnRedisStream(client, project, groupName, false, opts)
+}
+
+// NewConnRedisSentinel creates a connection like NewConnRedisStream to the master
+// of a Redis Sentinel deployment, following it when it fails over to a replica.
+// The consumer group is "delay" unless changed with WithConsumerGroup. Closing the
+// connection closes the client too.
+func NewConnRedisSentinel(masterName string, sentinelAddrs []string, opts ...RedisOption) (*Conn, error) {
+	if masterName == "" || len(sentinelAddrs) == 0 {
+		return nil, fmt.Errorf("delay: master name and sentinel addresses required")
+	}
+
+	client := redis.NewFailoverClient(&redis.FailoverOptions{
+		MasterName:    masterName,
+		SentinelAddrs: sentinelAddrs,
+	})
+	return newConnRedisStream(client, "", "delay", true, opts)
+}
+
+// NewConnRedisCluster creates a connection like NewConnRedisStream to a Redis
+// Cluster. Each queue is stored in a single stream, so its tasks live in the node
+// of its key. The consumer group is "delay" unless changed with WithConsumerGroup.
+// Closing the connection closes the client too.
+func NewConnRedisCluster(addrs []string, opts ...RedisOption) (*Conn, error) {
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("delay: cluster addresses required")
+	}
+
+	client := redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs: addrs,
+	})
+	return newConnRedisStream(client, "", "delay", true, opts)
+}
+
+func newConnRedisStream(client redis.UniversalClient, project, groupName string, ownClient bool, opts []RedisOption) (*Conn, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("delay: cannot get the hostname: %w", err)
+	}
+
+	conn := &Conn{
+		project: project,
+		redisStream: &redisStream{
+			client:   client,
+			group:    groupName,
+			consumer: fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+
+			ownClient: ownClient,
+		},
+	}
+	for _, opt := range opts {
+		opt(conn)
+	}
+
+	return conn, nil
+}
+
+// redisWithContext returns a client that runs the commands with the context if the
+// kind of client supports it.
+func redisWithContext(ctx context.Context, client redis.UniversalClient) redis.Cmdable {
+	switch client := client.(type) {
+	case *redis.Client:
+		return client.WithContext(ctx)
+	case *redis.ClusterClient:
+		return client.WithContext(ctx)
+	}
+
+	return client
+}
+
+func (rs *redisStream) sendTasks(queueName string, tasks []*pb.SendTask) error {
+	now := time.Now()
+	_, err := rs.client.Pipelined(func(pipe redis.Pipeliner) error {
+		for _, task := range tasks {
+			encoded, err := proto.Marshal(task)
+			if err != nil {
+				return fmt.Errorf("delay: cannot encode task: %w", err)
+			}
+			if eta := datetime.ParseTimestamp(task.MinEta); eta.After(now) {
+				pipe.ZAdd(queueName+streamDelayedSuffix, redis.Z{
+					Score:  float64(eta.UnixNano() / int64(time.Millisecond)),
+					Member: delayedMember(encoded),
+				})
+				continue
+			}
+			pipe.XAdd(&redis.XAddArgs{
+				Stream: queueName,
+				Values: map[string]interface{}{"task": encoded},
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("delay: cannot send tasks to the stream: %w", err)
+	}
+
+	return nil
+}
+
+// delayedMember builds the member of the sorted set of delayed tasks. The random
+// prefix keeps the identical tasks as different members.
+func delayedMember(encoded []byte) string {
+	return fmt.Sprintf("%016x:%s", rand.Uint64(), encoded)
+}
+
+// promote moves the delayed tasks whose ETA arrived to the stream. Only the consumer
+// that removes each task from the sorted set adds it to the stream.
+func (rs *redisStream) promote(queueName string, count int) error {
+	key := queueName + streamDelayedSuffix
+	members, err := rs.client.ZRangeByScore(key, redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10),
+		Count: int64(count),
+	}).Result()
+	if err != nil {
+		return fmt.Errorf("delay: cannot read delayed tasks: %w", err)
+	}
+
+	for _, member := range members {
+		removed, err := rs.client.ZRem(key, member).Result()
+		if err != nil {
+			return fmt.Errorf("delay: cannot read delayed tasks: %w", err)
+		}
+		if removed == 0 {
+			continue
+		}
+
+		encoded := member[strings.Index(member, ":")+1:]
+		err = rs.client.XAdd(&redis.XAddArgs{
+			Stream: queueName,
+			Values: map[string]interface{}{"task": encoded},
+		}).Err()
+		if err != nil {
+			// Return the task to the sorted set to try again later.
+			rs.client.ZAdd(key, redis.Z{Score: 0, Member: member})
+			return fmt.Errorf("delay: cannot send delayed task to the stream: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// delay moves a task read from the stream with an ETA in the future to the sorted
+// set, so waiting for it does not count as a failed delivery.
+func (rs *redisStream) delay(queueName string, msg redis.XMessage, eta time.Time) error {
+	encoded, _ := msg.Values["task"].(string)
+	_, err := rs.client.Pipelined(func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(queueName+streamDelayedSuffix, redis.Z{
+			Score:  float64(eta.UnixNano() / int64(time.Millisecond)),
+			Member: delayedMember([]byte(encoded)),
+		})
+		pipe.XAck(queueName, rs.group, msg.ID)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("delay: cannot delay task: %w", err)
+	}
+
+	return nil
+}
+
+// discard moves an entry of the stream that cannot be decoded to the dead-letter
+// stream as it is, so it is not claimed again after every visibility timeout.
+func (rs *redisStream) discard(queueName string, msg redis.XMessage) error {
+	_, err := rs.client.Pipelined(func(pipe redis.Pipeliner) error {
+		pipe.XAdd(&redis.XAddArgs{
+			Stream: queueName + ":dlq",
+			Values: msg.Values,
+		})
+		pipe.XAck(queueName, rs.group, msg.ID)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("delay: cannot discard task: %w", err)
+	}
+
+	return nil
+}
+
+func (rs *redisStream) createGroup(queueName string) error {
+	cmd := redis.NewStatusCmd("XGROUP", "CREATE", queueName, rs.group, "$", "MKSTREAM")
+	err := rs.client.Process(cmd)
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("delay: cannot create the consumer group: %w", err)
+	}
+
+	return nil
+}
+
+// claim takes the tasks of the group that have been waiting for an ack more than
+// the visibility timeout. It returns the messages and the number of deliveries
+// of each one of them.
+func (rs *redisStream) claim(queueName string, count int) ([]redis.XMessage, map[string]int64, error) {
+	pending, err := rs.client.XPendingExt(&redis.XPendingExtArgs{
+		Stream: queueName,
+		Group:  rs.group,
+		Start:  "-",
+		End:    "+",
+		Count:  int64(count),
+	}).Result()
+	if err != nil {
+		return nil, nil, fmt.Errorf("delay: cannot list pending tasks: %w", err)
+	}
+
+	deliveries := make(map[string]int64)
+	var ids []string
+	for _, p := range pending {
+		if p.Idle >= streamVisibilityTimeout {
+			ids = append(ids, p.Id)
+			deliveries[p.Id] = p.RetryCount
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil, nil
+	}
+
+	msgs, err := rs.client.XClaim(&redis.XClaimArgs{
+		Stream:   queueName,
+		Group:    rs.group,
+		Consumer: rs.consumer,
+		MinIdle:  streamVisibilityTimeout,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		return nil, nil, fmt.Errorf("delay: cannot claim pending tasks: %w", err)
+	}
+
+	return msgs, deliveries, nil
+}
+
+func (rs *redisStream) read(queueName string, count int) ([]redis.XMessage, error) {
+	streams, err := rs.client.XReadGroup(&redis.XReadGroupArgs{
+		Group:    rs.group,
+		Consumer: rs.consumer,
+		Streams:  []string{queueName, ">"},
+		Count:    int64(count),
+		Block:    5 * time.Second,
+	}).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("delay: cannot read tasks from the stream: %w", err)
+	}
+
+	var msgs []redis.XMessage
+	for _, stream := range streams {
+		msgs = append(msgs, stream.Messages...)
+	}
+
+	return msgs, nil
+}
+
+func (lis *Listener) listenRedisStream(queue QueueSpec, options handleOptions) error {
+	rs := queue.conn.redisStream
+	key := queue.redisKey()
+	if err := rs.createGroup(key); err != nil {
+		return err
+	}
+	batchSize := streamBatchSize
+	if options.prefetchCount > 0 {
+		batchSize = options.prefetchCount
+	}
+
+	for !lis.isStopping() {
+		// Messages are not read during a pause to leave them to other consumers.
+		if !lis.waitResumed(lis.ctx) {
+			return nil
+		}
+
+		if err := rs.promote(key, batchSize); err != nil {
+			return err
+		}
+		msgs, deliveries, err := rs.claim(key, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(msgs) == 0 {
+			msgs, err = rs.read(key, batchSize)
+			if err != nil {
+				return err
+			}
+		}
+
+		tasks := make([]*pb.Task, 0, len(msgs))
+		for _, msg := range msgs {
+			task, err := streamTask(queue, msg, deliveries[msg.ID])
+			if err != nil {
+				lis.logger.WithFields(log.Fields{
+					"error":   err.Error(),
+					"project": queue.conn.project,
+					"queue":   queue.name,
+					"task":    msg.ID,
+					"dlq":     key + ":dlq",
+				}).Error("Cannot decode incoming task, sent to the dead-letter stream")
+				if err := rs.discard(key, msg); err != nil {
+					return err
+				}
+				continue
+			}
+			// Tasks added to the stream with a future ETA, for example by older versions
+			// of the library, wait in the sorted set too.
+			if eta := datetime.ParseTimestamp(task.MinEta); eta.After(time.Now()) {
+				if err := rs.delay(key, msg, eta); err != nil {
+					return err
+				}
+				continue
+			}
+			tasks = append(tasks, task)
+		}
+		if options.priorityOrdering {
+			sort.SliceStable(tasks, func(i, j int) bool {
+				return tasks[i].Priority > tasks[j].Priority
+			})
+		}
+
+		// The tasks of the batch run in the pool of workers, or in their own goroutine
+		// without a pool, and the next batch is read when all of them finish.
+		var wg sync.WaitGroup
+		errs := make(chan error, len(tasks))
+		for _, task := range tasks {
+			task := task
+			wg.Add(1)
+			job := func() {
+				defer wg.Done()
+				if err := lis.handleStreamTask(queue, task); err != nil {
+					errs <- err
+				}
+			}
+			if lis.jobs == nil {
+				go job()
+				continue
+			}
+			select {
+			case lis.jobs <- job:
+			case options.reserved <- job:
+			}
+		}
+		wg.Wait()
+		close(errs)
+		if err := <-errs; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func streamTask(queue QueueSpec, msg redis.XMessage, deliveries int64) (*pb.Task, error) {
+	encoded, _ := msg.Values["task"].(string)
+	sendTask := new(pb.SendTask)
+	if err := proto.Unmarshal([]byte(encoded), sendTask); err != nil {
+		return nil, fmt.Errorf("delay: cannot decode incoming task: %w", err)
+	}
+
+	// Deliveries are read before claiming the task, so they are the previous attempts
+	// that were not acked.
+	retry := sendTask.Retry + int32(deliveries)
+
+	// Identifiers of the entries start with the milliseconds when they were added.
+	created := time.Now()
+	if ms, err := strconv.ParseInt(strings.Split(msg.ID, "-")[0], 10, 64); err == nil {
+		created = time.Unix(0, ms*int64(time.Millisecond))
+	}
+
+	return &pb.Task{
+		Code:             msg.ID,
+		Payload:          sendTask.Payload,
+		Created:          datetime.SerializeTimestamp(created),
+		Retry:            retry,
+		Project:          queue.conn.project,
+		QueueName:        queue.name,
+		MinEta:           sendTask.MinEta,
+		Headers:          sendTask.Headers,
+		Priority:         sendTask.Priority,
+		DeduplicationKey: sendTask.DeduplicationKey,
+		Ttl:              sendTask.Ttl,
+		Tags:             sendTask.Tags,
+	}, nil
+}
+
+func (lis *Listener) handleStreamTask(queue QueueSpec, task *pb.Task) error {
+	rs := queue.conn.redisStream
+
+	lis.logger.WithFields(log.Fields{
+		"project": task.Project,
+		"queue":   task.QueueName,
+		"task":    task.Code,
+	}).Debug("Task received")
+
+	requeued, err := lis.handleTask(lis.ctx, queue, task)
+	defer lis.callbacks.taskCompleted(lis.ctx, task, err)
+	if err != nil && !requeued {
+		if task.Retry+1 < streamMaxDeliveries && !IsNonRetryable(err) {
+			// The task will be claimed again after the visibility timeout.
+			return nil
+		}
+
+		if err := rs.sendTasks(queue.redisKey()+":dlq", []*pb.SendTask{{
+			Payload: task.Payload,
+			Headers: deadLetterHeaders(task, err),
+		}}); err != nil {
+			return err
+		}
+
+		reason := "Task exhausted all retry attempts, sent to the dead-letter stream"
+		if IsNonRetryable(err) {
+			reason = "Task failed with a non retryable error, sent to the dead-letter stream"
+		}
+		lis.logger.WithFields(log.Fields{
+			"project": task.Project,
+			"queue":   task.QueueName,
+			"task":    task.Code,
+			"retry":   task.Retry,
+			"dlq":     queue.redisKey() + ":dlq",
+		}).Error(reason)
+	}
+
+	if err := rs.client.XAck(queue.redisKey(), rs.group, task.Code).Err(); err != nil {
+		return fmt.Errorf("delay: cannot ack task: %w", err)
+	}
+
+	return nil
+}
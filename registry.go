@@ -0,0 +1,348 @@
+package delay
+
+import (
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// defaultRegistry stores the functions registered with Func.
+var defaultRegistry = NewRegistry()
+
+// gobMu serializes the registration of types in the gob package between the
+// registries.
+var gobMu sync.Mutex
+
+// Registry stores a set of functions independent from the rest. Listeners only
+// run the tasks of the functions of their registry, configured with WithRegistry.
+// By default functions and listeners use a global registry.
+type Registry struct {
+	mu    sync.RWMutex
+	funcs map[string]*Function
+
+	// functions that failed to register or were replaced by others with the same key
+	rejected []*Function
+}
+
+// NewRegistry creates a new empty registry of functions.
+func NewRegistry() *Registry {
+	return &Registry{
+		funcs: make(map[string]*Function),
+	}
+}
+
+// Func builds and registers a new task implementation in the registry.
+func (r *Registry) Func(key string, i interface{}, opts ...FuncOption) *Function {
+	// Derive unique, somewhat stable key for this func.
+	_, file, _, _ := runtime.Caller(1)
+
+	return r.register(file+":"+key, key, fmt.Sprintf("%s in %s", key, file), i, opts)
+}
+
+// FuncWithKey builds and registers a new task implementation in the registry with
+// an explicit full key.
+func (r *Registry) FuncWithKey(key string, i interface{}, opts ...FuncOption) *Function {
+	if key == "" || strings.Contains(key, ":") {
+		f := &Function{
+			fv:       reflect.ValueOf(i),
+			registry: r,
+			key:      key,
+			shortKey: key,
+			err:      fmt.Errorf("delay: explicit key %q cannot be empty or contain \":\"", key),
+		}
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.rejected = append(r.rejected, f)
+
+		return f
+	}
+
+	return r.register(key, key, key, i, opts)
+}
+
+// register builds and registers the function with the key. The short key is the one
+// provided by the user and the description is used in error messages to locate
+// the function.
+func (r *Registry) register(key, shortKey, description string, i interface{}, opts []FuncOption) *Function {
+	f := &Function{
+		fv:       reflect.ValueOf(i),
+		registry: r,
+		key:      key,
+		shortKey: shortKey,
+		codec:    GobCodec,
+
+		compressionThreshold: DefaultCompressionThreshold,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if f.schemaVersion > 0 {
+		f.key = versionedKey(key, f.schemaVersion)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t := f.fv.Type()
+	if t.Kind() != reflect.Func {
+		f.err = fmt.Errorf("delay: not a function")
+		r.rejected = append(r.rejected, f)
+		return f
+	}
+	if t.NumIn() == 0 || t.In(0) != contextType {
+		f.err = fmt.Errorf("delay: first argument must be context.Context")
+		r.rejected = append(r.rejected, f)
+		return f
+	}
+	if err := checkEncryptionKeys(f.encryptionKeys); err != nil {
+		f.err = err
+		r.rejected = append(r.rejected, f)
+		return f
+	}
+
+	// Register the function's arguments with the gob package.
+	// This is required because they are marshaled inside a []interface{}.
+	// gob.Register only expects to be called during initialization;
+	// that's fine because this function expects the same.
+	gobMu.Lock()
+	for i := 0; i < t.NumIn(); i++ {
+		// Only concrete types may be registered. If the argument has
+		// interface type, the client is resposible for registering the
+		// concrete types it will hold with RegisterGobType.
+		if t.In(i).Kind() == reflect.Interface {
+			continue
+		}
+		gob.Register(reflect.Zero(t.In(i)).Interface())
+	}
+	if f.resultStore != nil {
+		// The results are marshaled inside a []interface{} too.
+		for i := 0; i < t.NumOut(); i++ {
+			if t.Out(i).Kind() == reflect.Interface {
+				continue
+			}
+			gob.Register(reflect.Zero(t.Out(i)).Interface())
+		}
+	}
+	gobMu.Unlock()
+
+	if old := r.funcs[f.key]; old != nil {
+		old.err = fmt.Errorf("delay: multiple functions registered for %s", description)
+		r.rejected = append(r.rejected, old)
+	}
+	r.funcs[f.key] = f
+
+	return f
+}
+
+func (r *Registry) lookup(key string) *Function {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.funcs[key]
+}
+
+// lookupLatest returns the function with the highest schema version registered
+// for the key without version.
+func (r *Registry) lookupLatest(base string) *Function {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var latest *Function
+	for key, f := range r.funcs {
+		if baseKey(key) != base {
+			continue
+		}
+		if latest == nil || f.schemaVersion > latest.schemaVersion {
+			latest = f
+		}
+	}
+
+	return latest
+}
+
+func (r *Registry) unregister(f *Function) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, rejected := range r.rejected {
+		if rejected == f {
+			r.rejected = append(r.rejected[:i], r.rejected[i+1:]...)
+			return nil
+		}
+	}
+	if r.funcs[f.key] != f {
+		return fmt.Errorf("delay: function %s not registered", f.key)
+	}
+	delete(r.funcs, f.key)
+
+	return nil
+}
+
+// decryptionKeys returns the encryption keys of all the functions of the registry.
+func (r *Registry) decryptionKeys() [][]byte {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var keys [][]byte
+	for _, f := range r.funcs {
+		keys = append(keys, f.encryptionKeys...)
+	}
+
+	return keys
+}
+
+// ListFunctions returns all the functions of the registry sorted by key.
+func (r *Registry) ListFunctions() []*Function {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]*Function, 0, len(r.funcs))
+	for _, f := range r.funcs {
+		list = append(list, f)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].key < list[j].key
+	})
+
+	return list
+}
+
+// ValidateAll checks the registration of all the functions of the registry and
+// returns the errors of the invalid ones.
+func (r *Registry) ValidateAll() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	invalid := append([]*Function(nil), r.rejected...)
+	for _, f := range r.funcs {
+		if f.err != nil {
+			invalid = append(invalid, f)
+		}
+	}
+	if len(invalid) == 0 {
+		return nil
+	}
+	sort.Slice(invalid, func(i, j int) bool {
+		return invalid[i].key < invalid[j].key
+	})
+
+	return registrationError(invalid)
+}
+
+// Reset removes all the functions of the registry.
+func (r *Registry) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.funcs = make(map[string]*Function)
+	r.rejected = nil
+}
+
+// RegistrySnapshot is a copy of the functions of a registry at some point in time.
+type RegistrySnapshot struct {
+	funcs    map[string]*Function
+	rejected []*Function
+
+	// Registering functions with the same key changes the errors of the previous ones.
+	errs map[*Function]error
+}
+
+// Save returns a copy of the functions of the registry that can be restored later.
+func (r *Registry) Save() RegistrySnapshot {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	snap := RegistrySnapshot{
+		funcs:    make(map[string]*Function, len(r.funcs)),
+		rejected: append([]*Function(nil), r.rejected...),
+		errs:     make(map[*Function]error),
+	}
+	for key, f := range r.funcs {
+		snap.funcs[key] = f
+		snap.errs[f] = f.err
+	}
+	for _, f := range r.rejected {
+		snap.errs[f] = f.err
+	}
+
+	return snap
+}
+
+// Restore replaces the functions of the registry with the ones of the snapshot.
+func (r *Registry) Restore(snap RegistrySnapshot) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.funcs = make(map[string]*Function, len(snap.funcs))
+	for key, f := range snap.funcs {
+		r.funcs[key] = f
+	}
+	r.rejected = append([]*Function(nil), snap.rejected...)
+	for f, err := range snap.errs {
+		f.err = err
+	}
+}
+
+// ListFunctions returns all the registered functions sorted by key.
+func ListFunctions() []*Function {
+	return defaultRegistry.ListFunctions()
+}
+
+// ValidateAll checks the registration of all the functions and returns the errors
+// of the invalid ones, the same errors that would be returned later when sending
+// tasks to them. Call it at the start of the application to fail fast.
+func ValidateAll() error {
+	return defaultRegistry.ValidateAll()
+}
+
+// ResetRegistry removes all the registered functions. It is designed to clean up
+// the registry between tests. The types of the arguments stay registered in the
+// gob package, which cannot forget them, but that does not affect new functions.
+func ResetRegistry() {
+	defaultRegistry.Reset()
+}
+
+// SaveRegistry returns a copy of the registered functions. Tests can register their
+// own functions after saving it and call RestoreRegistry when they finish to leave
+// the registry as it was:
+//
+//	snap := delay.SaveRegistry()
+//	defer delay.RestoreRegistry(snap)
+func SaveRegistry() RegistrySnapshot {
+	return defaultRegistry.Save()
+}
+
+// RestoreRegistry replaces the registered functions with the ones of the snapshot
+// returned by SaveRegistry.
+func RestoreRegistry(snap RegistrySnapshot) {
+	defaultRegistry.Restore(snap)
+}
+
+// RegisterGobType registers the concrete type of v to send it inside the arguments
+// or the fields of the arguments typed as an interface. The types of the arguments
+// of the functions are registered automatically, but not the ones stored in their
+// interfaces. Like gob.Register it panics if other type was registered with the
+// same name.
+func RegisterGobType(v interface{}) {
+	gobMu.Lock()
+	defer gobMu.Unlock()
+
+	gob.Register(v)
+}
+
+// registrationError combines the errors of multiple invalid functions.
+type registrationError []*Function
+
+func (invalid registrationError) Error() string {
+	msgs := make([]string, len(invalid))
+	for i, f := range invalid {
+		msgs[i] = f.key + ": " + strings.TrimPrefix(f.err.Error(), "delay: ")
+	}
+
+	return fmt.Sprintf("delay: %d invalid functions:\n\t%s", len(invalid), strings.Join(msgs, "\n\t"))
+}
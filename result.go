@@ -0,0 +1,238 @@
+package delay
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/go-redis/redis"
+	log "github.com/sirupsen/logrus"
+
+	pb "github.com/altipla-consulting/delay/queues"
+)
+
+// HeaderResultID is the header with the identifier used to save the result of the
+// tasks sent with CallAsync.
+const HeaderResultID = "delay-result-id"
+
+// ErrResultNotReady is returned by the result stores when the task has not finished
+// yet.
+var ErrResultNotReady = errors.New("delay: result not ready")
+
+// ResultStore saves the values returned by the functions so the callers of
+// CallAsync can read them from other instances of the application.
+type ResultStore interface {
+	// Save stores the encoded result of the task.
+	Save(ctx context.Context, id string, result []byte) error
+
+	// Load returns the encoded result of the task or ErrResultNotReady if it was
+	// not saved yet.
+	Load(ctx context.Context, id string) ([]byte, error)
+}
+
+// WithResultStore saves the values returned by the function in the store to read
+// them with the handles returned by CallAsync.
+func WithResultStore(rs ResultStore) FuncOption {
+	return func(f *Function) {
+		f.resultStore = rs
+	}
+}
+
+// taskResult is the encoded result of a task saved in the store.
+type taskResult struct {
+	Values []interface{}
+	Error  string
+}
+
+// TaskHandle follows a task sent with CallAsync to read its result.
+type TaskHandle struct {
+	// ID identifies the result of the task in the store.
+	ID string
+
+	// Code is the code assigned by the queues server. Other backends do not assign
+	// codes when sending the tasks and it will be empty.
+	Code string
+
+	f      *Function
+	result *taskResult
+}
+
+// Minimum and maximum intervals to check if the result of a task is ready.
+const (
+	resultPollMin = 100 * time.Millisecond
+	resultPollMax = 2 * time.Second
+)
+
+// CallAsync builds a task invocation and sends it to the queue like Call does. It
+// returns a handle to wait for the task and read the values returned by the
+// function. The function should be registered with WithResultStore.
+func (f *Function) CallAsync(ctx context.Context, queue QueueSpec, args ...interface{}) (*TaskHandle, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if f.resultStore == nil {
+		return nil, fmt.Errorf("delay: function %s has no result store", f.key)
+	}
+
+	id, err := newResultID()
+	if err != nil {
+		return nil, err
+	}
+	task, err := f.Task(args...)
+	if err != nil {
+		return nil, err
+	}
+	if task.Headers == nil {
+		task.Headers = make(map[string]string)
+	}
+	task.Headers[HeaderResultID] = id
+
+	codes, err := queue.sendTasks(ctx, []*pb.SendTask{task})
+	if err != nil {
+		return nil, err
+	}
+	handle := &TaskHandle{
+		ID: id,
+		f:  f,
+	}
+	if len(codes) > 0 {
+		handle.Code = codes[0]
+	}
+
+	return handle, nil
+}
+
+func newResultID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("delay: cannot generate result id: %w", err)
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+// Wait blocks until the task finishes or the context expires. It returns the error
+// of the function if it failed and will not be retried anymore.
+func (handle *TaskHandle) Wait(ctx context.Context) error {
+	if handle.result == nil {
+		interval := resultPollMin
+		for {
+			data, err := handle.f.resultStore.Load(ctx, handle.ID)
+			if err == nil {
+				var result taskResult
+				if err := handle.f.codec.Decode(data, &result); err != nil {
+					return fmt.Errorf("delay: cannot decode result: %w", err)
+				}
+				handle.result = &result
+				break
+			}
+			if !errors.Is(err, ErrResultNotReady) {
+				return fmt.Errorf("delay: cannot load result: %w", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(interval):
+			}
+			if interval *= 2; interval > resultPollMax {
+				interval = resultPollMax
+			}
+		}
+	}
+
+	if handle.result.Error != "" {
+		return fmt.Errorf("delay: task failed: %s", handle.result.Error)
+	}
+
+	return nil
+}
+
+// Result waits for the task and decodes the first value returned by the function,
+// ignoring the error, in the pointer out.
+func (handle *TaskHandle) Result(ctx context.Context, out interface{}) error {
+	if err := handle.Wait(ctx); err != nil {
+		return err
+	}
+
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("delay: result should be a non-nil pointer: %T", out)
+	}
+	if len(handle.result.Values) == 0 {
+		return fmt.Errorf("delay: function %s does not return any value", handle.f.key)
+	}
+
+	value := handle.result.Values[0]
+	if value == nil {
+		rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+		return nil
+	}
+	v, err := convertArg(handle.f.codec, value, rv.Elem().Type())
+	if err != nil {
+		return fmt.Errorf("delay: cannot decode result: %w", err)
+	}
+	rv.Elem().Set(v)
+
+	return nil
+}
+
+// saveResult saves the result of the tasks sent with CallAsync. Errors are logged
+// without failing the task, that already finished.
+func (lis *Listener) saveResult(ctx context.Context, f *Function, task *pb.Task, values []interface{}, taskErr error) {
+	id := task.Headers[HeaderResultID]
+	if f.resultStore == nil || id == "" {
+		return
+	}
+
+	result := taskResult{Values: values}
+	if taskErr != nil {
+		result.Error = taskErr.Error()
+	}
+	data, err := f.codec.Encode(result)
+	if err == nil {
+		err = f.resultStore.Save(ctx, id, data)
+	}
+	if err != nil {
+		lis.logger.WithFields(log.Fields{
+			"error":   err.Error(),
+			"project": task.Project,
+			"queue":   task.QueueName,
+			"task":    task.Code,
+			"headers": task.Headers,
+		}).Error("Cannot save task result")
+	}
+}
+
+type redisResultStore struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+// NewRedisResultStore saves the results of the tasks in Redis during ttl. Keys are
+// prefixed with "delay-result:".
+func NewRedisResultStore(client *redis.Client, ttl time.Duration) ResultStore {
+	return &redisResultStore{
+		client: client,
+		prefix: "delay-result:",
+		ttl:    ttl,
+	}
+}
+
+func (store *redisResultStore) Save(ctx context.Context, id string, result []byte) error {
+	return store.client.WithContext(ctx).Set(store.prefix+id, result, store.ttl).Err()
+}
+
+func (store *redisResultStore) Load(ctx context.Context, id string) ([]byte, error) {
+	result, err := store.client.WithContext(ctx).Get(store.prefix + id).Bytes()
+	if err == redis.Nil {
+		return nil, ErrResultNotReady
+	}
+
+	return result, err
+}
@@ -0,0 +1,106 @@
+package delay
+
+import (
+	"reflect"
+	"regexp"
+)
+
+// ArgSanitizer returns a copy of the arguments of a task safe to include in the
+// logs, for example replacing the sensitive values with "[REDACTED]". It should not
+// modify the original arguments.
+type ArgSanitizer func(functionKey string, args []interface{}) []interface{}
+
+// Redacted replaces the sensitive values sanitized by PIISanitizer.
+const Redacted = "[REDACTED]"
+
+var (
+	emailPattern = regexp.MustCompile(`[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}`)
+	phonePattern = regexp.MustCompile(`(\+\d{1,3}[\s.-]?)?\(?\b\d{3}\)?[\s.-]?\d{3}[\s.-]?\d{3,4}\b`)
+)
+
+// WithArgSanitizer logs the arguments of the tasks of the function after passing
+// them through the sanitizer. The function still receives the original arguments.
+// Without a sanitizer the arguments are never logged.
+func WithArgSanitizer(fn ArgSanitizer) FuncOption {
+	return func(f *Function) {
+		f.sanitizer = fn
+	}
+}
+
+func (f *Function) sanitizeArgs(args []interface{}) []interface{} {
+	if f.sanitizer == nil {
+		return nil
+	}
+
+	return f.sanitizer(f.key, args)
+}
+
+// PIISanitizer is an ArgSanitizer that replaces the emails and phone numbers found
+// in the strings of the arguments, including the exported fields of structs and
+// the elements of slices and maps.
+func PIISanitizer(functionKey string, args []interface{}) []interface{} {
+	sanitized := make([]interface{}, len(args))
+	for i, arg := range args {
+		if arg == nil {
+			continue
+		}
+		v := reflect.New(reflect.TypeOf(arg)).Elem()
+		v.Set(deepCopy(reflect.ValueOf(arg)))
+		redactValue(v, make(map[uintptr]bool))
+		sanitized[i] = v.Interface()
+	}
+
+	return sanitized
+}
+
+func redactString(s string) string {
+	s = emailPattern.ReplaceAllString(s, Redacted)
+	return phonePattern.ReplaceAllString(s, Redacted)
+}
+
+// redactValue replaces the sensitive strings inside the value, that should be
+// settable.
+func redactValue(v reflect.Value, visited map[uintptr]bool) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.CanSet() {
+			v.SetString(redactString(v.String()))
+		}
+
+	case reflect.Ptr:
+		if v.IsNil() || visited[v.Pointer()] {
+			return
+		}
+		visited[v.Pointer()] = true
+		redactValue(v.Elem(), visited)
+
+	case reflect.Interface:
+		if v.IsNil() || !v.CanSet() {
+			return
+		}
+		elem := reflect.New(v.Elem().Type()).Elem()
+		elem.Set(v.Elem())
+		redactValue(elem, visited)
+		v.Set(elem)
+
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Field(i).CanSet() {
+				redactValue(v.Field(i), visited)
+			}
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			redactValue(v.Index(i), visited)
+		}
+
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(v.MapIndex(key))
+			redactValue(elem, visited)
+			v.SetMapIndex(key, elem)
+		}
+	}
+}
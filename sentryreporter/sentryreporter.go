@@ -0,0 +1,26 @@
+// Package sentryreporter reports the errors of the delay listeners to Sentry.
+package sentryreporter
+
+import (
+	"context"
+
+	"github.com/altipla-consulting/sentry"
+)
+
+// SentryErrorReporter sends the errors of the tasks to Sentry. It implements
+// the delay.ErrorReporter interface.
+type SentryErrorReporter struct {
+	client *sentry.Client
+}
+
+// New creates a new reporter that sends the errors to the Sentry DSN.
+func New(dsn string) *SentryErrorReporter {
+	return &SentryErrorReporter{
+		client: sentry.NewClient(dsn),
+	}
+}
+
+// Report sends the error to Sentry.
+func (reporter *SentryErrorReporter) Report(ctx context.Context, err error) {
+	reporter.client.ReportInternal(ctx, err)
+}
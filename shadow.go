@@ -0,0 +1,133 @@
+package delay
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	pb "github.com/altipla-consulting/delay/queues"
+)
+
+// HeaderShadow marks the copies of the tasks sent to the shadow function.
+const HeaderShadow = "delay-shadow"
+
+// Maximum time to send the copy of a task to the shadow queue.
+const shadowSendTimeout = 10 * time.Second
+
+// Internal headers of the tasks that are not copied to the shadow queue, so the
+// copy does not save the results or look like a dead-lettered task.
+var shadowSkipHeaders = map[string]bool{
+	HeaderResultID:           true,
+	HeaderDeadLetterError:    true,
+	HeaderDeadLetterRetry:    true,
+	HeaderDeadLetterQueue:    true,
+	HeaderDeadLetterFunction: true,
+}
+
+type shadowFunction struct {
+	f     *Function
+	queue QueueSpec
+}
+
+// Shadow sends a copy of each task of the function to the queue after running it
+// the first time, to run it with shadowFn as well. It is designed to test a new implementation with
+// the real traffic without affecting the results: errors of the shadow function are
+// logged and reported but the tasks are not retried.
+//
+// The shadow function should receive the same arguments as the function. The
+// listener of the shadow queue should have the function in its registry.
+func (f *Function) Shadow(shadowFn interface{}, shadowQueue QueueSpec) error {
+	fv := reflect.ValueOf(shadowFn)
+	if fv.Kind() != reflect.Func {
+		return fmt.Errorf("delay: shadow of %s is not a function", f.key)
+	}
+	ft, st := f.fv.Type(), fv.Type()
+	if st.NumIn() != ft.NumIn() || st.IsVariadic() != ft.IsVariadic() {
+		return fmt.Errorf("delay: shadow of %s should receive the same arguments", f.key)
+	}
+	for i := 0; i < ft.NumIn(); i++ {
+		if st.In(i) != ft.In(i) {
+			return fmt.Errorf("delay: shadow of %s should receive the same arguments", f.key)
+		}
+	}
+
+	f.shadow = &shadowFunction{
+		f: &Function{
+			fv:       fv,
+			registry: f.registry,
+			key:      f.key,
+			shortKey: f.shortKey,
+			timeout:  f.timeout,
+			codec:    f.codec,
+		},
+		queue: shadowQueue,
+	}
+
+	return nil
+}
+
+func isShadowTask(task *pb.Task) bool {
+	return task.Headers[HeaderShadow] != ""
+}
+
+// sendShadowTask sends in the background a copy of the task with the same payload
+// to the shadow queue of the function.
+func (lis *Listener) sendShadowTask(f *Function, task *pb.Task) {
+	headers := make(map[string]string, len(task.Headers)+1)
+	for k, v := range task.Headers {
+		if !shadowSkipHeaders[k] {
+			headers[k] = v
+		}
+	}
+	headers[HeaderShadow] = "true"
+
+	shadow := &pb.SendTask{
+		Payload:  task.Payload,
+		Headers:  headers,
+		Priority: task.Priority,
+		Tags:     task.Tags,
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shadowSendTimeout)
+		defer cancel()
+
+		if err := f.shadow.queue.SendTasks(ctx, []*pb.SendTask{shadow}); err != nil {
+			lis.logger.WithFields(log.Fields{
+				"error":   err.Error(),
+				"project": task.Project,
+				"queue":   task.QueueName,
+				"task":    task.Code,
+				"shadow":  f.shadow.queue.name,
+			}).Error("Cannot send task to the shadow queue")
+		}
+	}()
+}
+
+// runShadowTask runs the shadow function of the task. Errors are logged and reported
+// but never returned, so the task is not retried.
+func (lis *Listener) runShadowTask(ctx context.Context, f *Function, codec Codec, task *pb.Task, args []interface{}) {
+	if f.shadow == nil {
+		lis.logger.WithFields(log.Fields{
+			"project": task.Project,
+			"queue":   task.QueueName,
+			"task":    task.Code,
+		}).Warning("Shadow task without shadow function")
+		return
+	}
+
+	if _, err := lis.invoke(ctx, f.shadow.f, codec, args); err != nil {
+		lis.logger.WithFields(log.Fields{
+			"error":   err.Error(),
+			"project": task.Project,
+			"queue":   task.QueueName,
+			"task":    task.Code,
+		}).Error("Shadow task handler failed")
+
+		if lis.errorReporter != nil {
+			lis.errorReporter.Report(ctx, err)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package delay
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ListenerSnapshot is a point-in-time view of the state of a listener.
+type ListenerSnapshot struct {
+	// Running is true if the listener is handling at least one queue and it was
+	// not stopped.
+	Running bool `json:"running"`
+
+	// ActiveTasks is the number of tasks that are running right now.
+	ActiveTasks int64 `json:"activeTasks"`
+
+	// TotalReceived is the number of tasks received from the queues.
+	TotalReceived uint64 `json:"totalReceived"`
+
+	// TotalSucceeded is the number of tasks that finished without errors.
+	TotalSucceeded uint64 `json:"totalSucceeded"`
+
+	// TotalFailed is the number of tasks that returned an error.
+	TotalFailed uint64 `json:"totalFailed"`
+
+	// UptimeSince is the time when the listener was created.
+	UptimeSince time.Time `json:"uptimeSince"`
+
+	// PerFunction contains the stats of each function indexed by its full key.
+	PerFunction map[string]FunctionStats `json:"perFunction"`
+}
+
+// FunctionStats are the stats of the tasks of a single function.
+type FunctionStats struct {
+	// Received is the number of tasks of the function that started running.
+	Received uint64 `json:"received"`
+
+	// Succeeded is the number of tasks of the function that finished without errors.
+	Succeeded uint64 `json:"succeeded"`
+
+	// Failed is the number of tasks of the function that returned an error.
+	Failed uint64 `json:"failed"`
+
+	// AvgDuration is the average time the function spent running, including the
+	// executions that failed.
+	AvgDuration time.Duration `json:"avgDuration"`
+}
+
+// listenerStats accumulates the counters of the snapshots of a listener.
+type listenerStats struct {
+	mu        sync.Mutex
+	started   time.Time
+	handled   bool
+	handling  int
+	received  uint64
+	succeeded uint64
+	failed    uint64
+	functions map[string]*functionStats
+
+	// Tasks finished in each of the last seconds to compute the error rate.
+	recent [errorRateWindow]rateBucket
+}
+
+type functionStats struct {
+	FunctionStats
+	total time.Duration
+}
+
+func (stats *listenerStats) function(key string) *functionStats {
+	if stats.functions == nil {
+		stats.functions = make(map[string]*functionStats)
+	}
+	fs := stats.functions[key]
+	if fs == nil {
+		fs = new(functionStats)
+		stats.functions[key] = fs
+	}
+
+	return fs
+}
+
+func (stats *listenerStats) queueHandled() {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.handled = true
+	stats.handling++
+}
+
+func (stats *listenerStats) queueExited() {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.handling--
+}
+
+func (stats *listenerStats) taskReceived() {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.received++
+}
+
+func (stats *listenerStats) taskFinished(err error) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	if err != nil {
+		stats.failed++
+	} else {
+		stats.succeeded++
+	}
+
+	now := time.Now().Unix()
+	bucket := &stats.recent[now%errorRateWindow]
+	if bucket.second != now {
+		*bucket = rateBucket{second: now}
+	}
+	bucket.finished++
+	if err != nil {
+		bucket.failed++
+	}
+}
+
+func (stats *listenerStats) functionStarted(key string) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.function(key).Received++
+}
+
+func (stats *listenerStats) functionFinished(key string, duration time.Duration, err error) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	fs := stats.function(key)
+	if err != nil {
+		fs.Failed++
+	} else {
+		fs.Succeeded++
+	}
+	fs.total += duration
+	fs.AvgDuration = fs.total / time.Duration(fs.Succeeded+fs.Failed)
+}
+
+// Snapshot returns the current state of the listener. All the counters are read
+// at the same time, so they are consistent between them.
+func (lis *Listener) Snapshot() ListenerSnapshot {
+	lis.stats.mu.Lock()
+	defer lis.stats.mu.Unlock()
+
+	snapshot := ListenerSnapshot{
+		Running:        lis.stats.handling > 0 && !lis.isStopping(),
+		ActiveTasks:    lis.ActiveTaskCount(),
+		TotalReceived:  lis.stats.received,
+		TotalSucceeded: lis.stats.succeeded,
+		TotalFailed:    lis.stats.failed,
+		UptimeSince:    lis.stats.started,
+		PerFunction:    make(map[string]FunctionStats, len(lis.stats.functions)),
+	}
+	for key, fs := range lis.stats.functions {
+		snapshot.PerFunction[key] = fs.FunctionStats
+	}
+
+	return snapshot
+}
+
+// DebugHandler returns an HTTP handler that serves the snapshot of the listener
+// as JSON. It can be mounted in a path like /debug/delay for health dashboards.
+func (lis *Listener) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if err := json.NewEncoder(w).Encode(lis.Snapshot()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
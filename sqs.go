@@ -0,0 +1,330 @@
+package delay
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/altipla-consulting/datetime"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/golang/protobuf/proto"
+	log "github.com/sirupsen/logrus"
+
+	pb "github.com/altipla-consulting/delay/queues"
+)
+
+const (
+	// Time a received message stays hidden from other listeners. It is extended
+	// periodically while the task is running.
+	sqsVisibilityTimeout = 30 * time.Second
+
+	// Maximum delay allowed by SQS.
+	sqsMaxDelay = 15 * time.Minute
+
+	// Maximum messages sent or received in each request.
+	sqsBatchSize = 10
+)
+
+type sqsBackend struct {
+	client    *sqs.Client
+	urlPrefix string
+}
+
+// NewConnSQS creates a connection that stores the tasks in Amazon SQS. The URL of
+// each queue is the prefix followed by the name of the queue, for example
+// "https://sqs.eu-west-1.amazonaws.com/123456789012/" to use the queues of that account.
+//
+// Queues whose name ends with ".fifo" deliver the tasks in order. They use the
+// deduplication key of the tasks as the SQS deduplication ID. They do not support
+// delays per message, so sending tasks with an ETA in the future fails.
+//
+// Tasks with an ETA later than the maximum delay of SQS, 15 minutes, are sent again
+// to the queue when received until their ETA arrives.
+//
+// Tasks that fail without a retry policy become visible again after the visibility
+// timeout. Configure a redrive policy in the queue to send them to a dead-letter
+// queue after some deliveries.
+func NewConnSQS(ctx context.Context, queueURLPrefix string, cfg aws.Config) (*Conn, error) {
+	if queueURLPrefix == "" {
+		return nil, fmt.Errorf("delay: queue URL prefix required")
+	}
+
+	return &Conn{
+		backend: &sqsBackend{
+			client:    sqs.NewFromConfig(cfg),
+			urlPrefix: queueURLPrefix,
+		},
+	}, nil
+}
+
+func (b *sqsBackend) queueURL(queueName string) *string {
+	return aws.String(b.urlPrefix + queueName)
+}
+
+func (b *sqsBackend) sendTasks(ctx context.Context, queueName string, tasks []*pb.SendTask) error {
+	fifo := strings.HasSuffix(queueName, ".fifo")
+	if fifo {
+		for _, task := range tasks {
+			if datetime.ParseTimestamp(task.MinEta).After(time.Now()) {
+				return fmt.Errorf("delay: sqs fifo queues do not support ETAs")
+			}
+		}
+	}
+
+	for len(tasks) > 0 {
+		batch := tasks
+		if len(batch) > sqsBatchSize {
+			batch = batch[:sqsBatchSize]
+		}
+		tasks = tasks[len(batch):]
+
+		entries := make([]types.SendMessageBatchRequestEntry, 0, len(batch))
+		for i, task := range batch {
+			encoded, err := proto.Marshal(task)
+			if err != nil {
+				return fmt.Errorf("delay: cannot encode task: %w", err)
+			}
+			body := base64.StdEncoding.EncodeToString(encoded)
+
+			entry := types.SendMessageBatchRequestEntry{
+				Id:          aws.String(strconv.Itoa(i)),
+				MessageBody: aws.String(body),
+			}
+			if fifo {
+				// Deduplication IDs have a limited length and charset, the hash avoids both problems.
+				dedup := task.DeduplicationKey
+				if dedup == "" {
+					dedup = body
+				}
+				hash := sha256.Sum256([]byte(dedup))
+				entry.MessageDeduplicationId = aws.String(hex.EncodeToString(hash[:]))
+				entry.MessageGroupId = aws.String(queueName)
+			} else if task.MinEta != nil {
+				// Longer ETAs are sent again when receiving the task.
+				delay := time.Until(datetime.ParseTimestamp(task.MinEta))
+				if delay > sqsMaxDelay {
+					delay = sqsMaxDelay
+				}
+				if delay > 0 {
+					entry.DelaySeconds = int32(delay / time.Second)
+				}
+			}
+			entries = append(entries, entry)
+		}
+
+		reply, err := b.client.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+			QueueUrl: b.queueURL(queueName),
+			Entries:  entries,
+		})
+		if err != nil {
+			return fmt.Errorf("delay: cannot send tasks to SQS: %w", err)
+		}
+		if len(reply.Failed) > 0 {
+			return fmt.Errorf("delay: cannot send %d tasks to SQS: %s", len(reply.Failed), aws.ToString(reply.Failed[0].Message))
+		}
+	}
+
+	return nil
+}
+
+func (b *sqsBackend) listen(ctx context.Context, queueName string, handler func(task *pb.Task) error) error {
+	fifo := strings.HasSuffix(queueName, ".fifo")
+
+	for ctx.Err() == nil {
+		reply, err := b.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl: b.queueURL(queueName),
+			AttributeNames: []types.QueueAttributeName{
+				types.QueueAttributeName(types.MessageSystemAttributeNameApproximateReceiveCount),
+				types.QueueAttributeName(types.MessageSystemAttributeNameSentTimestamp),
+			},
+			MaxNumberOfMessages: sqsBatchSize,
+			VisibilityTimeout:   int32(sqsVisibilityTimeout / time.Second),
+			WaitTimeSeconds:     20,
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("delay: cannot receive tasks from SQS: %w", err)
+		}
+
+		// Tasks of FIFO queues run one after the other to keep the order.
+		var wg sync.WaitGroup
+		errs := make(chan error, len(reply.Messages))
+		for _, msg := range reply.Messages {
+			if fifo {
+				if err := b.handleMessage(queueName, msg, handler); err != nil {
+					return err
+				}
+				continue
+			}
+
+			wg.Add(1)
+			go func(msg types.Message) {
+				defer wg.Done()
+				if err := b.handleMessage(queueName, msg, handler); err != nil {
+					errs <- err
+				}
+			}(msg)
+		}
+		wg.Wait()
+		close(errs)
+		if err := <-errs; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *sqsBackend) handleMessage(queueName string, msg types.Message, handler func(task *pb.Task) error) error {
+	// The listener could be stopping, the messages should be acknowledged anyway.
+	ctx := context.Background()
+
+	task, err := sqsTask(msg)
+	if err != nil {
+		// The message is received again after the visibility timeout and the redrive
+		// policy of the queue moves it to its dead-letter queue if configured.
+		log.WithFields(log.Fields{
+			"queue": queueName,
+			"task":  aws.ToString(msg.MessageId),
+			"error": err.Error(),
+		}).Error("Cannot decode incoming task")
+		return nil
+	}
+
+	// Send the task again with a new delay until its ETA arrives. Hiding the same
+	// message would count each wait as a failed receive of the task. FIFO queues
+	// cannot delay it and would block the group, the ETA is ignored there.
+	if eta := time.Until(datetime.ParseTimestamp(task.MinEta)); eta > 0 && !strings.HasSuffix(queueName, ".fifo") {
+		delayed := &pb.SendTask{
+			Payload:          task.Payload,
+			MinEta:           task.MinEta,
+			Retry:            task.Retry,
+			Headers:          task.Headers,
+			Priority:         task.Priority,
+			DeduplicationKey: task.DeduplicationKey,
+			Ttl:              task.Ttl,
+			Tags:             task.Tags,
+		}
+		if err := b.sendTasks(ctx, queueName, []*pb.SendTask{delayed}); err != nil {
+			return err
+		}
+		return b.deleteMessage(ctx, queueName, msg)
+	}
+
+	done := make(chan struct{})
+	heartbeat := make(chan struct{})
+	go func() {
+		defer close(heartbeat)
+		ticker := time.NewTicker(sqsVisibilityTimeout * 2 / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := b.changeVisibility(ctx, queueName, msg, sqsVisibilityTimeout); err != nil {
+					log.WithFields(log.Fields{
+						"queue": queueName,
+						"task":  task.Code,
+						"error": err.Error(),
+					}).Error("Cannot extend the visibility timeout of the task")
+				}
+			}
+		}
+	}()
+	err = handler(task)
+	close(done)
+	<-heartbeat
+
+	if err != nil {
+		// The task will be received again after the visibility timeout.
+		return nil
+	}
+
+	return b.deleteMessage(ctx, queueName, msg)
+}
+
+func (b *sqsBackend) deleteMessage(ctx context.Context, queueName string, msg types.Message) error {
+	_, err := b.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      b.queueURL(queueName),
+		ReceiptHandle: msg.ReceiptHandle,
+	})
+	if err != nil {
+		return fmt.Errorf("delay: cannot ack task: %w", err)
+	}
+
+	return nil
+}
+
+func (b *sqsBackend) changeVisibility(ctx context.Context, queueName string, msg types.Message, timeout time.Duration) error {
+	_, err := b.client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          b.queueURL(queueName),
+		ReceiptHandle:     msg.ReceiptHandle,
+		VisibilityTimeout: int32(timeout / time.Second),
+	})
+	if err != nil {
+		return fmt.Errorf("delay: cannot change the visibility timeout of the task: %w", err)
+	}
+
+	return nil
+}
+
+func sqsTask(msg types.Message) (*pb.Task, error) {
+	encoded, err := base64.StdEncoding.DecodeString(aws.ToString(msg.Body))
+	if err != nil {
+		return nil, fmt.Errorf("delay: cannot decode incoming task: %w", err)
+	}
+	sendTask := new(pb.SendTask)
+	if err := proto.Unmarshal(encoded, sendTask); err != nil {
+		return nil, fmt.Errorf("delay: cannot decode incoming task: %w", err)
+	}
+
+	// Receives include the current one.
+	retry := sendTask.Retry
+	if receives, err := strconv.ParseInt(msg.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)], 10, 32); err == nil && receives > 1 {
+		retry += int32(receives - 1)
+	}
+
+	created := time.Now()
+	if ms, err := strconv.ParseInt(msg.Attributes[string(types.MessageSystemAttributeNameSentTimestamp)], 10, 64); err == nil {
+		created = time.Unix(0, ms*int64(time.Millisecond))
+	}
+
+	return &pb.Task{
+		Code:             aws.ToString(msg.MessageId),
+		Payload:          sendTask.Payload,
+		Created:          datetime.SerializeTimestamp(created),
+		Retry:            retry,
+		MinEta:           sendTask.MinEta,
+		Headers:          sendTask.Headers,
+		Priority:         sendTask.Priority,
+		DeduplicationKey: sendTask.DeduplicationKey,
+		Ttl:              sendTask.Ttl,
+		Tags:             sendTask.Tags,
+	}, nil
+}
+
+func (b *sqsBackend) ping(ctx context.Context) error {
+	_, err := b.client.ListQueues(ctx, &sqs.ListQueuesInput{
+		MaxResults: aws.Int32(1),
+	})
+	if err != nil {
+		return fmt.Errorf("delay: cannot ping SQS: %w", err)
+	}
+
+	return nil
+}
+
+func (b *sqsBackend) close() error {
+	return nil
+}
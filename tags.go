@@ -0,0 +1,80 @@
+package delay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/altipla-consulting/datetime"
+
+	pb "github.com/altipla-consulting/delay/queues"
+)
+
+// errTaskFiltered is returned for the tasks rejected by the tag filter of the queue.
+var errTaskFiltered = errors.New("delay: task rejected by the tag filter")
+
+// Time the tasks rejected by the tag filter wait in the queue before they are
+// received again, to avoid receiving them in a loop if no other listener runs them.
+const filteredReleaseDelay = time.Second
+
+// WithTags adds tags to the task, for example with the resources required by the
+// listeners that can run it. The listeners select the tasks they run with
+// WithTagFilter.
+func WithTags(tags ...string) TaskOption {
+	return func(task *pb.SendTask) {
+		task.Tags = append(task.Tags, tags...)
+	}
+}
+
+// WithTagFilter only runs the tasks of the queue whose tags are accepted by the
+// filter. The rest are returned to the queue without running them and without
+// using their retries, so other listener of the queue can receive them after a
+// second, although the same listener may receive them again while the queues
+// server does not route the tasks by their tags.
+// Kafka queues cannot return the tasks, and Redis streams, SQS and NATS queues count
+// them as failed deliveries, so they do not support filters.
+func WithTagFilter(fn func(tags []string) bool) HandleOption {
+	return func(opts *handleOptions) {
+		opts.tagFilter = fn
+	}
+}
+
+// withTagFilter returns a reference to the same queue that filters the tasks it
+// receives by their tags.
+func (queue QueueSpec) withTagFilter(fn func(tags []string) bool) QueueSpec {
+	options := new(queueOptions)
+	if queue.options != nil {
+		*options = *queue.options
+	}
+	options.tagFilter = fn
+	queue.options = options
+
+	return queue
+}
+
+// releaseTask sends again to the queue a task rejected by the tag filter, without
+// counting it as a failed delivery of the queues server. The deduplication key is
+// not sent again, otherwise the server would discard the task as a duplicate.
+func (lis *Listener) releaseTask(ctx context.Context, queue QueueSpec, task *pb.Task) error {
+	eta := time.Now().Add(filteredReleaseDelay)
+	released := &pb.SendTask{
+		Payload:  task.Payload,
+		MinEta:   datetime.SerializeTimestamp(eta),
+		Retry:    task.Retry,
+		Headers:  task.Headers,
+		Priority: task.Priority,
+		Ttl:      retryTTL(task, eta),
+		Tags:     task.Tags,
+	}
+	if err := queue.SendTasks(ctx, []*pb.SendTask{released}); err != nil {
+		return fmt.Errorf("delay: cannot release filtered task: %w", err)
+	}
+
+	return nil
+}
+
+// acceptsTask returns true if the tags of the task pass the filter of the queue.
+func (queue QueueSpec) acceptsTask(task *pb.Task) bool {
+	return queue.options == nil || queue.options.tagFilter == nil || queue.options.tagFilter(task.Tags)
+}
@@ -0,0 +1,115 @@
+// Package testdelay contains helpers to check the tasks sent by the application
+// in the unit tests without a queues server.
+package testdelay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/altipla-consulting/delay"
+)
+
+// MockConn is an in-memory connection that captures the tasks sent to its queues.
+// The embedded connection can be used anywhere a *delay.Conn is expected.
+type MockConn struct {
+	*delay.InMemoryConn
+
+	opts []delay.ListenerOption
+}
+
+// NewMockConn creates a new in-memory connection that is closed when the test
+// finishes. The options configure the listener that runs the tasks in DrainAndProcess,
+// for example delay.WithRegistry to run the functions of other registry.
+func NewMockConn(t testing.TB, opts ...delay.ListenerOption) *MockConn {
+	conn := &MockConn{
+		InMemoryConn: delay.NewInMemoryConn("test"),
+		opts:         opts,
+	}
+	t.Cleanup(func() {
+		if err := conn.Close(); err != nil && !errors.Is(err, delay.ErrConnClosed) {
+			t.Errorf("testdelay: cannot close connection: %v", err)
+		}
+	})
+
+	return conn
+}
+
+// AssertEnqueued fails the test if no task of the function was sent to any queue
+// of the connection.
+func AssertEnqueued(t testing.TB, conn *MockConn, f *delay.Function) {
+	t.Helper()
+
+	calls, err := enqueuedCalls(conn, f)
+	if err != nil {
+		t.Fatalf("testdelay: %v", err)
+	}
+	if len(calls) == 0 {
+		t.Errorf("testdelay: no task enqueued for %s", f.Key())
+	}
+}
+
+// AssertEnqueuedWith fails the test if no task of the function was sent to any
+// queue of the connection with exactly the arguments, excluding the context.
+func AssertEnqueuedWith(t testing.TB, conn *MockConn, f *delay.Function, args ...interface{}) {
+	t.Helper()
+
+	calls, err := enqueuedCalls(conn, f)
+	if err != nil {
+		t.Fatalf("testdelay: %v", err)
+	}
+	if len(calls) == 0 {
+		t.Errorf("testdelay: no task enqueued for %s", f.Key())
+		return
+	}
+	if args == nil {
+		args = []interface{}{}
+	}
+	for _, call := range calls {
+		if reflect.DeepEqual(call, args) {
+			return
+		}
+	}
+
+	t.Errorf("testdelay: no task enqueued for %s with args %v, enqueued calls: %v", f.Key(), args, calls)
+}
+
+// enqueuedCalls returns the arguments of the pending tasks of the function in all
+// the queues of the connection.
+func enqueuedCalls(conn *MockConn, f *delay.Function) ([][]interface{}, error) {
+	queues, err := conn.ListQueues(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	var calls [][]interface{}
+	for _, queue := range queues {
+		for _, task := range conn.Tasks(queue.Name) {
+			args, err := f.DecodeTask(task)
+			if err != nil {
+				if errors.Is(err, delay.ErrOtherFunction) {
+					continue
+				}
+				return nil, fmt.Errorf("cannot decode task of queue %s: %w", queue.Name, err)
+			}
+			calls = append(calls, args)
+		}
+	}
+
+	return calls, nil
+}
+
+// DrainAndProcess executes synchronously all the pending tasks of the connection
+// with the registered functions, including the new tasks sent while running them.
+// It returns the error of the first task that failed and was not retried.
+func DrainAndProcess(ctx context.Context, conn *MockConn) error {
+	return conn.ProcessAll(ctx, conn.opts...)
+}
+
+// Reset removes all the captured tasks of the connection, for example between
+// sub-tests.
+func Reset(conn *MockConn) {
+	conn.InMemoryConn.Reset()
+}
@@ -0,0 +1,85 @@
+package delay
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	pb "github.com/altipla-consulting/delay/queues"
+)
+
+const tracerName = "github.com/altipla-consulting/delay"
+
+// Trace context is always propagated inside the headers of the tasks with the
+// W3C format, independently of the global propagator of the application.
+var tracePropagator = propagation.TraceContext{}
+
+// WithTracerProvider changes the provider of the tracer that records the execution
+// of the tasks. By default the global provider of OpenTelemetry is used.
+func WithTracerProvider(tp trace.TracerProvider) ListenerOption {
+	return func(lis *Listener) {
+		lis.tracerProvider = tp
+	}
+}
+
+// headersCarrier adapts the headers of a task to the OpenTelemetry propagators.
+type headersCarrier map[string]string
+
+func (hc headersCarrier) Get(key string) string {
+	return hc[key]
+}
+
+func (hc headersCarrier) Set(key string, value string) {
+	hc[key] = value
+}
+
+func (hc headersCarrier) Keys() []string {
+	keys := make([]string, 0, len(hc))
+	for k := range hc {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
+// injectTraceContext saves the span of the context in the headers of the task. Tasks
+// that already have a trace context, like the retries, keep the original one.
+func injectTraceContext(ctx context.Context, task *pb.SendTask) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return
+	}
+	for _, field := range tracePropagator.Fields() {
+		if task.Headers[field] != "" {
+			return
+		}
+	}
+
+	if task.Headers == nil {
+		task.Headers = make(map[string]string)
+	}
+	tracePropagator.Inject(ctx, headersCarrier(task.Headers))
+}
+
+// startTaskSpan starts the span of the execution of the task as a child of the
+// span that sent it.
+func (lis *Listener) startTaskSpan(ctx context.Context, task *pb.Task) (context.Context, trace.Span) {
+	if task.Headers != nil {
+		ctx = tracePropagator.Extract(ctx, headersCarrier(task.Headers))
+	}
+
+	tp := lis.tracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+
+	return tp.Tracer(tracerName).Start(ctx, "delay.handle",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("delay.task_code", task.Code),
+			attribute.Int64("delay.retry", int64(task.Retry)),
+		),
+	)
+}
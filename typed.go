@@ -0,0 +1,64 @@
+package delay
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	pb "github.com/altipla-consulting/delay/queues"
+)
+
+// TypedFunc is a function that receives a single argument of type T apart from the
+// context. The compiler checks the type of the argument when sending tasks to it.
+// Functions that need multiple arguments can group them in a struct.
+type TypedFunc[T any] struct {
+	f   *Function
+	err error
+}
+
+// TypedFuncOf checks the signature of the function and wraps it to send tasks with
+// an argument of type T. If the function does not receive a T after the context the
+// error is returned when sending the tasks, like the rest of registration errors.
+func TypedFuncOf[T any](f *Function) *TypedFunc[T] {
+	tf := &TypedFunc[T]{f: f}
+	if f.err != nil {
+		return tf
+	}
+
+	t := f.fv.Type()
+	argType := reflect.TypeOf((*T)(nil)).Elem()
+	if t.NumIn() != 2 || !argType.AssignableTo(t.In(1)) {
+		tf.err = fmt.Errorf("delay: function %s does not receive a single argument of type %s", f.key, argType)
+	}
+
+	return tf
+}
+
+// Function returns the underlying function.
+func (tf *TypedFunc[T]) Function() *Function {
+	return tf.f
+}
+
+// Task builds a task to call the function with the argument.
+func (tf *TypedFunc[T]) Task(arg T, opts ...TaskOption) (*pb.SendTask, error) {
+	if tf.err != nil {
+		return nil, tf.err
+	}
+
+	args := []interface{}{arg}
+	for _, opt := range opts {
+		args = append(args, opt)
+	}
+
+	return tf.f.Task(args...)
+}
+
+// Call sends a task to the queue to call the function with the argument.
+func (tf *TypedFunc[T]) Call(ctx context.Context, queue QueueSpec, arg T, opts ...TaskOption) error {
+	task, err := tf.Task(arg, opts...)
+	if err != nil {
+		return err
+	}
+
+	return queue.SendTasks(ctx, []*pb.SendTask{task})
+}